@@ -0,0 +1,332 @@
+package vidio
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+type Audio struct {
+	filename   string        // Audio Filename.
+	stream     int           // Audio Stream Index.
+	samplerate int           // Sample rate of decoded audio in Hz.
+	channels   int           // Number of channels of decoded audio.
+	layout     string        // Channel layout of decoded audio.
+	format     string        // Sample format of decoded audio. s16le or f32le.
+	samples    int           // Samples per channel returned by each Read().
+	fps        float64       // Frame rate of the synchronized video. 0 if not synchronized.
+	start      float64       // Start of the synchronized video stream relative to the start of the file in seconds.
+	duration   float64       // Duration of the synchronized video stream in seconds. 0 if unknown.
+	frame      int           // Number of video frames read in synchronized mode.
+	size       int           // Number of bytes in the buffer from the last Read().
+	buffer     []byte        // Raw sample data.
+	pipe       io.ReadCloser // Stdout pipe for ffmpeg process.
+	cmd        *exec.Cmd     // ffmpeg command.
+}
+
+// Optional parameters for Audio.
+type AudioOptions struct {
+	Stream     int    // Zero-indexed audio stream index.
+	Format     string // Sample format: s16le (default) or f32le.
+	SampleRate int    // Sample rate in Hz. Default is the sample rate of the stream.
+	Channels   int    // Number of channels. Default is the number of channels of the stream.
+	Layout     string // Channel layout, e.g. mono, stereo or 5.1. Overrides Channels.
+	Samples    int    // Samples per channel returned by each Read(). Default 1024. Ignored when synchronized.
+}
+
+// Number of channels of the common channel layouts.
+var layoutChannels = map[string]int{
+	"mono":   1,
+	"stereo": 2,
+	"2.1":    3,
+	"3.0":    3,
+	"4.0":    4,
+	"quad":   4,
+	"5.0":    5,
+	"5.1":    6,
+	"6.1":    7,
+	"7.1":    8,
+}
+
+func (audio *Audio) FileName() string {
+	return audio.filename
+}
+
+// Returns the zero-indexed audio stream index.
+func (audio *Audio) Stream() int {
+	return audio.stream
+}
+
+// Sample rate of decoded audio in Hz.
+func (audio *Audio) SampleRate() int {
+	return audio.samplerate
+}
+
+// Number of channels of decoded audio.
+func (audio *Audio) Channels() int {
+	return audio.channels
+}
+
+// Channel layout of decoded audio. Empty if not specified.
+func (audio *Audio) Layout() string {
+	return audio.layout
+}
+
+// Sample format of decoded audio. Either s16le or f32le.
+func (audio *Audio) Format() string {
+	return audio.format
+}
+
+// Returns true if each Read() returns the samples covering one video frame.
+func (audio *Audio) Synchronized() bool {
+	return audio.fps > 0
+}
+
+// Interleaved sample data from the last Read().
+func (audio *Audio) Buffer() []byte {
+	return audio.buffer[:audio.size]
+}
+
+// Number of samples per channel in the buffer from the last Read().
+func (audio *Audio) Samples() int {
+	return audio.size / (audio.channels * audio.bytesPerSample())
+}
+
+// Creates a new Audio struct which decodes the given audio stream of the file to raw samples.
+func NewAudio(filename string, options *AudioOptions) (*Audio, error) {
+	if !exists(filename) {
+		return nil, fmt.Errorf("vidio: file %s does not exist", filename)
+	}
+	// Check if ffmpeg and ffprobe are installed on the users machine.
+	if err := installed("ffmpeg"); err != nil {
+		return nil, err
+	}
+	if err := installed("ffprobe"); err != nil {
+		return nil, err
+	}
+
+	if options == nil {
+		options = &AudioOptions{}
+	}
+
+	streams, err := ffprobe(filename, "a")
+	if err != nil {
+		return nil, err
+	}
+	if options.Stream < 0 || options.Stream >= len(streams) {
+		return nil, fmt.Errorf("vidio: no audio stream with index %d found in %s", options.Stream, filename)
+	}
+	data := streams[options.Stream]
+
+	audio := &Audio{
+		filename:   filename,
+		stream:     options.Stream,
+		samplerate: options.SampleRate,
+		channels:   options.Channels,
+		layout:     options.Layout,
+		format:     options.Format,
+		samples:    options.Samples,
+	}
+
+	switch audio.format {
+	case "":
+		audio.format = "s16le"
+	case "s16le", "f32le":
+	default:
+		return nil, fmt.Errorf("vidio: unsupported sample format %s", audio.format)
+	}
+
+	if audio.samplerate == 0 {
+		audio.samplerate = int(parse(data["sample_rate"]))
+	}
+	if audio.layout != "" {
+		channels, ok := layoutChannels[audio.layout]
+		if !ok {
+			return nil, fmt.Errorf("vidio: unsupported channel layout %s", audio.layout)
+		}
+		audio.channels = channels
+	}
+	if audio.channels == 0 {
+		audio.channels = int(parse(data["channels"]))
+	}
+	if audio.samplerate == 0 || audio.channels == 0 {
+		return nil, fmt.Errorf("vidio: could not determine the sample rate and channels of %s", filename)
+	}
+
+	if audio.samples <= 0 {
+		audio.samples = 1024
+	}
+
+	return audio, nil
+}
+
+// Creates a new Audio struct which decodes audio from the file of the given video. Each Read()
+// returns exactly the samples covering the time span of the next video frame, starting at the
+// first frame. The audio is aligned to the start of the video stream and padded with silence,
+// so there are samples for every frame.
+func NewSyncedAudio(video *Video, options *AudioOptions) (*Audio, error) {
+	if video.fps <= 0 {
+		return nil, fmt.Errorf("vidio: frame rate of %s is unknown", video.filename)
+	}
+
+	audio, err := NewAudio(video.filename, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// ffmpeg shifts the input timestamps by the start time of the file, so the video
+	// starts at its own start time relative to the file start.
+	offset, err := formatStart(video.filename)
+	if err != nil {
+		return nil, err
+	}
+
+	audio.fps = video.fps
+	audio.start = math.Max(video.start-offset, 0)
+	audio.duration = video.duration
+
+	return audio, nil
+}
+
+// Size of a single sample of a single channel in bytes.
+func (audio *Audio) bytesPerSample() int {
+	if audio.format == "f32le" {
+		return 4
+	}
+	return 2
+}
+
+// Number of samples per channel covering the given video frame. Frame boundaries are rounded
+// to the nearest sample, so the total number of samples never drifts from the video.
+func (audio *Audio) frameSamples(frame int) int {
+	rate := float64(audio.samplerate) / audio.fps
+	return int(math.Round(float64(frame+1)*rate) - math.Round(float64(frame)*rate))
+}
+
+// Builds the ffmpeg arguments used to decode the audio stream into raw samples.
+func (audio *Audio) command() []string {
+	command := []string{
+		"-i", audio.filename,
+		"-loglevel", "quiet",
+		"-map", fmt.Sprintf("0:a:%d", audio.stream),
+		"-f", audio.format,
+		"-acodec", "pcm_" + audio.format,
+		"-ar", fmt.Sprintf("%d", audio.samplerate),
+		"-ac", fmt.Sprintf("%d", audio.channels),
+	}
+
+	filters := []string{}
+	if audio.layout != "" {
+		filters = append(filters, "aformat=channel_layouts="+audio.layout)
+	}
+	if audio.Synchronized() {
+		// Fill gaps and the start with silence so that sample 0 is at time 0, then cut the
+		// audio before the first video frame and pad the end with silence up to the end of
+		// the video. Without a known duration the audio ends with the audio stream, since
+		// unlimited padding never ends.
+		filters = append(filters, "aresample=async=1:first_pts=0")
+		if audio.start > 0 {
+			filters = append(filters, fmt.Sprintf("atrim=start=%s", seconds(audio.start)), "asetpts=PTS-STARTPTS")
+		}
+		if audio.duration > 0 {
+			filters = append(filters, "apad=whole_dur="+seconds(audio.duration))
+		}
+	}
+	if len(filters) > 0 {
+		command = append(command, "-af", strings.Join(filters, ","))
+	}
+	// Audio longer than the video is cut at its end.
+	if audio.Synchronized() && audio.duration > 0 {
+		command = append(command, "-t", seconds(audio.duration))
+	}
+
+	return append(command, "-")
+}
+
+// Once the user calls Read() for the first time on an Audio struct,
+// the ffmpeg command which is used to read the audio is started.
+func (audio *Audio) init() error {
+	// If user exits with Ctrl+C, stop ffmpeg process.
+	audio.cleanup()
+
+	cmd := exec.Command("ffmpeg", audio.command()...)
+	audio.cmd = cmd
+
+	pipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	audio.pipe = pipe
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// Synchronized frames differ by at most one sample in length.
+	samples := audio.samples
+	if audio.Synchronized() {
+		samples = int(math.Ceil(float64(audio.samplerate)/audio.fps)) + 1
+	}
+	audio.buffer = make([]byte, samples*audio.channels*audio.bytesPerSample())
+
+	return nil
+}
+
+// Reads the next chunk of samples into the buffer. In synchronized mode, reads the samples
+// covering the next video frame. If there are no more samples, returns false, otherwise true.
+func (audio *Audio) Read() bool {
+	// If cmd is nil, audio reading has not been initialized.
+	if audio.cmd == nil {
+		if err := audio.init(); err != nil {
+			return false
+		}
+	}
+
+	samples := audio.samples
+	if audio.Synchronized() {
+		samples = audio.frameSamples(audio.frame)
+		audio.frame++
+	}
+
+	size := samples * audio.channels * audio.bytesPerSample()
+	n, err := io.ReadFull(audio.pipe, audio.buffer[:size])
+	audio.size = n
+	// The final chunk may be shorter than the requested number of samples.
+	if err != nil && (err != io.ErrUnexpectedEOF || n == 0) {
+		audio.Close()
+		return false
+	}
+	return true
+}
+
+// Closes the pipe and stops the ffmpeg process.
+func (audio *Audio) Close() {
+	if audio.pipe != nil {
+		audio.pipe.Close()
+	}
+	if audio.cmd != nil {
+		audio.cmd.Wait()
+	}
+}
+
+// Stops the "cmd" process running when the user presses Ctrl+C.
+// https://stackoverflow.com/questions/11268943/is-it-possible-to-capture-a-ctrlc-signal-and-run-a-cleanup-function-in-a-defe.
+func (audio *Audio) cleanup() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		if audio.pipe != nil {
+			audio.pipe.Close()
+		}
+		if audio.cmd != nil {
+			audio.cmd.Process.Kill()
+		}
+		os.Exit(1)
+	}()
+}
@@ -0,0 +1,48 @@
+//go:build !windows
+// +build !windows
+
+package vidio
+
+import (
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Pipe passing the audio samples of a VideoWriter to ffmpeg as a second input.
+// The read end is inherited by ffmpeg as file descriptor 3.
+type audioPipe struct {
+	reader *os.File // Read end passed to ffmpeg.
+	writer *os.File // Write end the samples are written to.
+}
+
+func newAudioPipe() (*audioPipe, error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	return &audioPipe{reader: reader, writer: writer}, nil
+}
+
+// Input url ffmpeg reads the audio from.
+func (pipe *audioPipe) input() string {
+	return "pipe:3"
+}
+
+// Passes the pipe to the ffmpeg command. Must be called before the command is started.
+func (pipe *audioPipe) attach(cmd *exec.Cmd) {
+	cmd.ExtraFiles = []*os.File{pipe.reader}
+}
+
+// Returns the write end of the pipe once ffmpeg has been started.
+func (pipe *audioPipe) open(process *os.Process) io.WriteCloser {
+	// The read end is owned by the ffmpeg process now.
+	pipe.reader.Close()
+	return pipe.writer
+}
+
+// Closes both ends of a pipe that was never opened.
+func (pipe *audioPipe) Close() error {
+	pipe.reader.Close()
+	return pipe.writer.Close()
+}
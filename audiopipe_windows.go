@@ -0,0 +1,136 @@
+package vidio
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var (
+	kernel32             = syscall.NewLazyDLL("kernel32.dll")
+	procCreateNamedPipe  = kernel32.NewProc("CreateNamedPipeW")
+	procConnectNamedPipe = kernel32.NewProc("ConnectNamedPipe")
+)
+
+// https://learn.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-createnamedpipew.
+const (
+	pipeAccessOutbound      = 0x00000002
+	pipeFirstInstance       = 0x00080000
+	pipeRejectRemoteClients = 0x00000008
+	pipeBufferSize          = 1 << 16
+	errorPipeConnected      = syscall.Errno(535)
+)
+
+// Pipe passing the audio samples of a VideoWriter to ffmpeg as a second input.
+// Windows processes cannot inherit file descriptors other than stdin, stdout and stderr,
+// so ffmpeg opens a named pipe instead.
+type audioPipe struct {
+	name   string         // Pipe name, e.g. \\.\pipe\vidio-1234-5678.
+	handle syscall.Handle // Server end of the pipe.
+}
+
+func newAudioPipe() (*audioPipe, error) {
+	name := fmt.Sprintf(`\\.\pipe\vidio-%d-%d`, os.Getpid(), time.Now().UnixNano())
+	path, err := syscall.UTF16PtrFromString(name)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, _, err := procCreateNamedPipe.Call(
+		uintptr(unsafe.Pointer(path)),
+		pipeAccessOutbound|pipeFirstInstance,
+		pipeRejectRemoteClients, // Byte stream with blocking reads and writes.
+		1,                       // Only ffmpeg connects to the pipe.
+		pipeBufferSize,
+		pipeBufferSize,
+		0,
+		0,
+	)
+	if syscall.Handle(handle) == syscall.InvalidHandle {
+		return nil, fmt.Errorf("vidio: failed to create the audio pipe: %w", err)
+	}
+
+	return &audioPipe{name: name, handle: syscall.Handle(handle)}, nil
+}
+
+// Input url ffmpeg reads the audio from.
+func (pipe *audioPipe) input() string {
+	return pipe.name
+}
+
+// The named pipe is opened by name, so nothing is passed to the ffmpeg command.
+func (pipe *audioPipe) attach(cmd *exec.Cmd) {}
+
+// Returns the write end of the pipe once ffmpeg has been started. ffmpeg opens its inputs in order,
+// so it only opens the pipe after probing the frames on stdin. Writes wait for the connection.
+func (pipe *audioPipe) open(process *os.Process) io.WriteCloser {
+	named := &namedPipe{
+		name:      pipe.name,
+		handle:    pipe.handle,
+		file:      os.NewFile(uintptr(pipe.handle), pipe.name),
+		connected: make(chan struct{}),
+	}
+
+	go func() {
+		defer close(named.connected)
+		if ok, _, err := procConnectNamedPipe.Call(uintptr(named.handle), 0); ok == 0 && err != errorPipeConnected {
+			named.err = fmt.Errorf("vidio: failed to connect the audio pipe: %w", err)
+		}
+	}()
+
+	// If ffmpeg exits without opening the pipe, connect to it so that pending writes fail
+	// instead of waiting forever.
+	go func() {
+		if handle, err := syscall.OpenProcess(syscall.SYNCHRONIZE, false, uint32(process.Pid)); err == nil {
+			syscall.WaitForSingleObject(handle, syscall.INFINITE)
+			syscall.CloseHandle(handle)
+		}
+		select {
+		case <-named.connected:
+		default:
+			if client, err := os.OpenFile(named.name, os.O_RDONLY, 0); err == nil {
+				client.Close()
+			}
+		}
+	}()
+
+	return named
+}
+
+// Closes a pipe that was never opened.
+func (pipe *audioPipe) Close() error {
+	return syscall.CloseHandle(pipe.handle)
+}
+
+// Server end of a named pipe whose writes wait until ffmpeg has connected.
+type namedPipe struct {
+	name      string         // Pipe name.
+	handle    syscall.Handle // Server end of the pipe.
+	file      *os.File       // File wrapping the handle.
+	connected chan struct{}  // Closed once ConnectNamedPipe has returned.
+	err       error          // Error of ConnectNamedPipe.
+}
+
+func (named *namedPipe) Write(data []byte) (int, error) {
+	<-named.connected
+	if named.err != nil {
+		return 0, named.err
+	}
+	return named.file.Write(data)
+}
+
+// Waits until ffmpeg has read all written data and closes the pipe.
+func (named *namedPipe) Close() error {
+	select {
+	case <-named.connected:
+		if named.err == nil {
+			syscall.FlushFileBuffers(named.handle)
+		}
+	default:
+	}
+	return named.file.Close()
+}
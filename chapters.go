@@ -0,0 +1,74 @@
+package vidio
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// A chapter of a video file.
+type Chapter struct {
+	Title string  // Title of the chapter.
+	Start float64 // Start time in seconds.
+	End   float64 // End time in seconds.
+}
+
+// Returns the chapters of the video file in order. The chapters are cached on the video.
+func (video *Video) Chapters() ([]Chapter, error) {
+	if video.chapters != nil {
+		return video.chapters, nil
+	}
+
+	data, err := probe(video.filename, "-show_chapters")
+	if err != nil {
+		return nil, err
+	}
+
+	chapters := []Chapter{}
+	for _, chapter := range data {
+		chapters = append(chapters, parseChapter(chapter))
+	}
+	video.chapters = chapters
+
+	return chapters, nil
+}
+
+// Parses a chapter from the ffprobe chapter output.
+func parseChapter(data map[string]string) Chapter {
+	return Chapter{
+		Title: data["tag:title"],
+		Start: parse(data["start_time"]),
+		End:   parse(data["end_time"]),
+	}
+}
+
+// Checks that every chapter ends after it starts.
+func validateChapters(chapters []Chapter) error {
+	for _, chapter := range chapters {
+		if chapter.Start < 0 || chapter.End <= chapter.Start {
+			return fmt.Errorf("vidio: chapter %q must end after it starts", chapter.Title)
+		}
+	}
+	return nil
+}
+
+// Formats the given chapters as an ffmetadata file with millisecond timestamps.
+// https://ffmpeg.org/ffmpeg-formats.html#Metadata-1.
+func formatChapters(chapters []Chapter) string {
+	builder := strings.Builder{}
+	builder.WriteString(";FFMETADATA1\n")
+	for _, chapter := range chapters {
+		builder.WriteString("[CHAPTER]\nTIMEBASE=1/1000\n")
+		fmt.Fprintf(&builder, "START=%d\n", int64(math.Round(chapter.Start*1000)))
+		fmt.Fprintf(&builder, "END=%d\n", int64(math.Round(chapter.End*1000)))
+		if chapter.Title != "" {
+			fmt.Fprintf(&builder, "title=%s\n", escapeMetadata(chapter.Title))
+		}
+	}
+	return builder.String()
+}
+
+// Escapes the special characters of ffmetadata values with a backslash.
+func escapeMetadata(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n").Replace(value)
+}
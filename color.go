@@ -0,0 +1,281 @@
+package vidio
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Color properties of a video stream. Values use the ffmpeg/ffprobe names.
+type Color struct {
+	Space     string // Matrix coefficients, e.g. bt709, bt470bg, bt2020nc.
+	Primaries string // Color primaries, e.g. bt709, bt2020.
+	Transfer  string // Transfer characteristics, e.g. bt709, smpte2084, arib-std-b67.
+	Range     string // tv (limited) or pc (full).
+}
+
+// Returns true if the transfer characteristics are PQ (HDR10) or HLG.
+func (color Color) HDR() bool {
+	return color.Transfer == "smpte2084" || color.Transfer == "arib-std-b67"
+}
+
+// HDR mastering display and content light level metadata.
+// Chromaticity coordinates are CIE 1931 xy values.
+type HDRMetadata struct {
+	RedX, RedY     float64 // Red primary of the mastering display.
+	GreenX, GreenY float64 // Green primary of the mastering display.
+	BlueX, BlueY   float64 // Blue primary of the mastering display.
+	WhiteX, WhiteY float64 // White point of the mastering display.
+	MinLuminance   float64 // Minimum luminance of the mastering display in cd/m^2.
+	MaxLuminance   float64 // Maximum luminance of the mastering display in cd/m^2.
+	MaxCLL         int     // Maximum content light level in cd/m^2.
+	MaxFALL        int     // Maximum frame-average light level in cd/m^2.
+}
+
+// Parses the color properties from the ffprobe stream output.
+func parseColor(data map[string]string) Color {
+	color := Color{}
+	for key, value := range map[string]*string{
+		"color_space":     &color.Space,
+		"color_primaries": &color.Primaries,
+		"color_transfer":  &color.Transfer,
+		"color_range":     &color.Range,
+	} {
+		if v, ok := data[key]; ok && v != "unknown" {
+			*value = v
+		}
+	}
+	return color
+}
+
+// Returns the HDR mastering display and content light level metadata of the video.
+// The metadata is read from the stream side data, or from the first frame if the
+// container does not store it. Returns nil if the video has no HDR metadata.
+func (video *Video) HDRMetadata() (*HDRMetadata, error) {
+	if video.hdr != nil {
+		return video.hdr, nil
+	}
+
+	type sideData struct {
+		SideDataList []map[string]interface{} `json:"side_data_list"`
+	}
+	probe := struct {
+		Streams []sideData `json:"streams"`
+		Frames  []sideData `json:"frames"`
+	}{}
+
+	if err := ffprobeJSON(
+		video.filename,
+		&probe,
+		"-select_streams", fmt.Sprintf("v:%d", video.stream),
+		"-read_intervals", "%+#1",
+		"-show_streams",
+		"-show_frames",
+	); err != nil {
+		return nil, err
+	}
+
+	list := []map[string]interface{}{}
+	for _, data := range append(probe.Streams, probe.Frames...) {
+		list = append(list, data.SideDataList...)
+	}
+
+	hdr := parseHDRMetadata(list)
+	video.hdr = hdr
+	return hdr, nil
+}
+
+// Parses the "Mastering display metadata" and "Content light level metadata" side data.
+func parseHDRMetadata(list []map[string]interface{}) *HDRMetadata {
+	var hdr *HDRMetadata
+	mastering, light := false, false
+	for _, data := range list {
+		kind, _ := data["side_data_type"].(string)
+		switch {
+		case strings.HasPrefix(kind, "Mastering display metadata") && !mastering:
+			if hdr == nil {
+				hdr = &HDRMetadata{}
+			}
+			mastering = true
+			hdr.RedX, hdr.RedY = rational(data["red_x"]), rational(data["red_y"])
+			hdr.GreenX, hdr.GreenY = rational(data["green_x"]), rational(data["green_y"])
+			hdr.BlueX, hdr.BlueY = rational(data["blue_x"]), rational(data["blue_y"])
+			hdr.WhiteX, hdr.WhiteY = rational(data["white_point_x"]), rational(data["white_point_y"])
+			hdr.MinLuminance = rational(data["min_luminance"])
+			hdr.MaxLuminance = rational(data["max_luminance"])
+		case strings.HasPrefix(kind, "Content light level metadata") && !light:
+			if hdr == nil {
+				hdr = &HDRMetadata{}
+			}
+			light = true
+			hdr.MaxCLL = int(rational(data["max_content"]))
+			hdr.MaxFALL = int(rational(data["max_average"]))
+		}
+	}
+	return hdr
+}
+
+// Parses a ffprobe value which is either a number or a rational string such as "34000/50000".
+func rational(value interface{}) float64 {
+	switch v := value.(type) {
+	case float64:
+		return v
+	case string:
+		split := strings.Split(v, "/")
+		if len(split) == 2 {
+			denominator := parse(split[1])
+			if denominator == 0 {
+				return 0
+			}
+			return parse(split[0]) / denominator
+		}
+		return parse(v)
+	default:
+		return 0
+	}
+}
+
+// Returns the filter chain used to tone map HDR frames to SDR BT.709 with the given operator.
+// https://web.archive.org/web/20190722004804/https://stevens.li/guides/video/converting-hdr-to-sdr-with-ffmpeg/.
+func toneMapFilter(operator string) string {
+	return strings.Join([]string{
+		"zscale=t=linear:npl=100",
+		"format=gbrpf32le",
+		"zscale=p=bt709",
+		fmt.Sprintf("tonemap=tonemap=%s:desat=0", operator),
+		"zscale=t=bt709:m=bt709:r=tv",
+		"format=yuv420p",
+	}, ",")
+}
+
+// Returns true if the given pixel format stores RGB rather than YUV data.
+func rgbPixelFormat(pixfmt string) bool {
+	for _, prefix := range []string{"rgb", "bgr", "gbr", "argb", "abgr", "rgba", "bgra", "0rgb", "0bgr"} {
+		if strings.HasPrefix(pixfmt, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Fills in missing color properties the same way most players do: HD content is BT.709,
+// SD content is BT.601 (PAL or NTSC), and range is limited unless the pixel format is full range.
+func detectColor(color Color, width, height int, pixfmt string) Color {
+	rgb := rgbPixelFormat(pixfmt)
+	if color.Range == "" {
+		if rgb || strings.HasPrefix(pixfmt, "yuvj") {
+			color.Range = "pc"
+		} else {
+			color.Range = "tv"
+		}
+	}
+	if color.Space == "" {
+		switch {
+		case rgb:
+			color.Space = "gbr"
+		case width >= 1280 || height >= 720:
+			color.Space = "bt709"
+		case height == 576:
+			color.Space = "bt470bg"
+		default:
+			color.Space = "smpte170m"
+		}
+	}
+	if color.Primaries == "" {
+		switch color.Space {
+		case "bt470bg", "smpte170m", "smpte240m":
+			color.Primaries = color.Space
+		case "bt2020nc", "bt2020c":
+			color.Primaries = "bt2020"
+		default:
+			color.Primaries = "bt709"
+		}
+	}
+	if color.Transfer == "" {
+		switch color.Space {
+		case "bt470bg", "smpte170m":
+			color.Transfer = "smpte170m"
+		case "bt2020nc", "bt2020c":
+			color.Transfer = "bt2020-10"
+		default:
+			color.Transfer = "bt709"
+		}
+	}
+	return color
+}
+
+// Maps ffprobe matrix coefficient names to the names used by the swscale color matrix options.
+func swscaleMatrix(space string) string {
+	switch space {
+	case "bt709", "fcc", "smpte170m", "smpte240m":
+		return space
+	case "bt470bg":
+		return "bt470"
+	case "bt2020nc", "bt2020c":
+		return "bt2020"
+	default:
+		return "auto"
+	}
+}
+
+// Returns the colorspace filter converting the primaries and transfer characteristics of "in" to "out".
+// Returns an empty string if no conversion is required or possible.
+func colorspaceFilter(in, out Color) string {
+	if out.Primaries == "" && out.Transfer == "" {
+		return ""
+	}
+	// The colorspace filter only operates on YUV frames with SDR transfer characteristics.
+	if in.Space == "gbr" || in.HDR() || out.HDR() {
+		return ""
+	}
+	if out.Primaries == "" {
+		out.Primaries = in.Primaries
+	}
+	if out.Transfer == "" {
+		out.Transfer = in.Transfer
+	}
+	if out.Primaries == in.Primaries && out.Transfer == in.Transfer {
+		return ""
+	}
+	return fmt.Sprintf(
+		"colorspace=ispace=%s:iprimaries=%s:itrc=%s:irange=%s:space=%s:primaries=%s:trc=%s:range=%s",
+		in.Space, in.Primaries, in.Transfer, in.Range,
+		in.Space, out.Primaries, out.Transfer, in.Range,
+	)
+}
+
+// Returns the scale filter converting YUV frames with the given color properties to full range RGB.
+func rgbFilter(in Color) string {
+	return fmt.Sprintf("scale=in_color_matrix=%s:in_range=%s:out_range=pc", swscaleMatrix(in.Space), in.Range)
+}
+
+// Returns the scale filter options and ffmpeg output arguments used by the VideoWriter
+// to convert RGB frames to YUV with the given color properties and tag the output stream.
+// Returns empty values if no color properties are given.
+func writerColor(color Color) (string, []string) {
+	if color == (Color{}) {
+		return "", nil
+	}
+	if color.Space == "" {
+		switch color.Primaries {
+		case "bt470bg", "smpte170m", "smpte240m":
+			color.Space = color.Primaries
+		case "bt2020":
+			color.Space = "bt2020nc"
+		default:
+			color.Space = "bt709"
+		}
+	}
+	if color.Range == "" {
+		color.Range = "tv"
+	}
+
+	options := fmt.Sprintf("in_range=pc:out_color_matrix=%s:out_range=%s", swscaleMatrix(color.Space), color.Range)
+	args := []string{"-colorspace", color.Space, "-color_range", color.Range}
+	if color.Primaries != "" {
+		args = append(args, "-color_primaries", color.Primaries)
+	}
+	if color.Transfer != "" {
+		args = append(args, "-color_trc", color.Transfer)
+	}
+	return options, args
+}
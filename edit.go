@@ -0,0 +1,675 @@
+package vidio
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Trimming modes supported by Trim.
+const (
+	TrimCopy     = "copy"     // Stream copy, with the start snapped to the previous keyframe.
+	TrimSmart    = "smart"    // Stream copy, re-encoding only the partial GOPs at the edges.
+	TrimAccurate = "accurate" // Frame accurate, re-encoding the entire video.
+)
+
+// Encoders used to re-encode video of the given codec.
+var encoders = map[string]string{
+	"h264":       "libx264",
+	"hevc":       "libx265",
+	"vp8":        "libvpx",
+	"vp9":        "libvpx-vp9",
+	"av1":        "libaom-av1",
+	"mpeg4":      "mpeg4",
+	"mpeg2video": "mpeg2video",
+	"mjpeg":      "mjpeg",
+	"prores":     "prores_ks",
+}
+
+// Returns the encoder used to re-encode video of the given codec. Defaults to libx264.
+func encoderFor(codec string) string {
+	if encoder, ok := encoders[codec]; ok {
+		return encoder
+	}
+	return "libx264"
+}
+
+// Formats a time in seconds as an ffmpeg duration.
+func seconds(t float64) string {
+	return fmt.Sprintf("%.6f", t)
+}
+
+// Trims the input file to the time range [start, end) in seconds and writes it to the output file.
+// All audio, subtitle, data and attachment streams are kept, except for data streams with TrimSmart.
+//
+//	TrimCopy:     Stream copy without re-encoding. The start is moved back to the previous keyframe.
+//	TrimSmart:    Stream copy of all complete GOPs. Only the frames before the first keyframe and
+//	              after the last keyframe in the range are re-encoded with the same codec.
+//	              Data streams, e.g. timecode tracks, are dropped.
+//	TrimAccurate: Frame accurate. The video is re-encoded with the same codec.
+func Trim(input, output string, start, end float64, mode string) error {
+	if start < 0 || end <= start {
+		return fmt.Errorf("vidio: invalid trim range [%f, %f)", start, end)
+	}
+
+	video, err := NewVideo(input)
+	if err != nil {
+		return err
+	}
+
+	switch mode {
+	case TrimCopy:
+		keyframes, err := video.Keyframes()
+		if err != nil {
+			return err
+		}
+		offset, err := formatStart(input)
+		if err != nil {
+			return err
+		}
+		// Keyframe timestamps are absolute, while -ss is relative to the start of the file.
+		start = previousKeyframe(keyframes, start+offset) - offset
+		if start < 0 {
+			start = 0
+		}
+		return ffmpeg(
+			"-ss", seconds(start),
+			"-i", input,
+			"-t", seconds(end-start),
+			"-map", "0",
+			"-c", "copy",
+			"-avoid_negative_ts", "make_zero",
+			output,
+		)
+	case TrimSmart:
+		return smartTrim(video, output, start, end)
+	case TrimAccurate:
+		return ffmpeg(
+			"-ss", seconds(start),
+			"-i", input,
+			"-t", seconds(end-start),
+			"-map", "0",
+			"-c", "copy",
+			"-c:v", encoderFor(video.codec),
+			output,
+		)
+	default:
+		return fmt.Errorf("vidio: unsupported trim mode %s", mode)
+	}
+}
+
+// Returns the start time of the file in seconds, e.g. non-zero for many MPEG-TS and camera files.
+// Packet timestamps include it, while input seeking with -ss is relative to it.
+func formatStart(filename string) (float64, error) {
+	data, err := probe(filename, "-show_entries", "format=start_time")
+	if err != nil {
+		return 0, err
+	}
+	for _, entry := range data {
+		if start, ok := entry["start_time"]; ok {
+			return parse(start), nil
+		}
+	}
+	return 0, nil
+}
+
+// Profiles of the source that can be passed to its encoder when re-encoding.
+var encoderProfiles = map[string]map[string]bool{
+	"h264": {"baseline": true, "main": true, "high": true, "high10": true, "high422": true, "high444": true},
+	"hevc": {"main": true, "main10": true, "mainstillpicture": true},
+}
+
+// Returns the encoder settings matching the profile and level of an H.264 or HEVC stream as
+// reported by ffprobe, so re-encoded segments use the same parameter sets as copied ones.
+// Returns empty settings for other codecs and unknown profiles.
+func sourceSettings(codec, profile, level string) encoderSettings {
+	settings := encoderSettings{}
+	profiles, ok := encoderProfiles[codec]
+	if !ok {
+		return settings
+	}
+
+	// e.g. "Constrained Baseline" -> baseline, "High 4:4:4 Predictive" -> high444, "Main 10" -> main10.
+	profile = strings.ToLower(profile)
+	profile = strings.TrimPrefix(profile, "constrained ")
+	profile = strings.TrimSuffix(profile, " predictive")
+	profile = strings.NewReplacer(" ", "", ":", "").Replace(profile)
+	if profiles[profile] {
+		settings.profile = profile
+	}
+
+	// ffprobe reports the level as level_idc, which is 10 times the level for H.264
+	// and 30 times the level for HEVC.
+	if idc := parse(level); idc > 0 {
+		scale := 10.0
+		if codec == "hevc" {
+			scale = 30
+		}
+		settings.level = strconv.FormatFloat(idc/scale, 'f', 1, 64)
+	}
+
+	return settings
+}
+
+// Returns the timestamp of the last keyframe at or before the given time.
+func previousKeyframe(keyframes []Keyframe, t float64) float64 {
+	previous := 0.0
+	for _, keyframe := range keyframes {
+		// Allow for rounding in the timestamps reported by ffprobe.
+		if keyframe.Timestamp > t+1e-6 {
+			break
+		}
+		previous = keyframe.Timestamp
+	}
+	return previous
+}
+
+// Returns the timestamp of the first keyframe at or after the given time, or -1 if there is none.
+func nextKeyframe(keyframes []Keyframe, t float64) float64 {
+	for _, keyframe := range keyframes {
+		if keyframe.Timestamp >= t-1e-6 {
+			return keyframe.Timestamp
+		}
+	}
+	return -1
+}
+
+// Trims the video by copying all complete GOPs within [start, end) and re-encoding the partial
+// GOPs at the edges. The video segments are joined with the concat demuxer and the other
+// streams are trimmed separately.
+func smartTrim(video *Video, output string, start, end float64) error {
+	keyframes, err := video.Keyframes()
+	if err != nil {
+		return err
+	}
+
+	offset, err := formatStart(video.filename)
+	if err != nil {
+		return err
+	}
+
+	// Keyframe timestamps are absolute, while -ss is relative to the start of the file.
+	first := nextKeyframe(keyframes, start+offset)
+	last := previousKeyframe(keyframes, end+offset) - offset
+	// Without a complete GOP in the range there is nothing to copy.
+	if first < 0 || first-offset >= last {
+		return Trim(video.filename, output, start, end, TrimAccurate)
+	}
+	first -= offset
+
+	dir, err := os.MkdirTemp("", "vidio")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	// MPEG-TS keeps the parameter sets of every segment in-band, which allows
+	// the re-encoded and copied segments to be concatenated.
+	ext := ".mkv"
+	if video.codec == "h264" || video.codec == "hevc" {
+		ext = ".ts"
+	}
+
+	data, err := probe(
+		video.filename,
+		"-select_streams", fmt.Sprintf("v:%d", video.stream),
+		"-show_entries", "stream=profile,level",
+	)
+	if err != nil {
+		return err
+	}
+	source := encoderSettings{}
+	if len(data) > 0 {
+		source = sourceSettings(video.codec, data[0]["profile"], data[0]["level"])
+	}
+
+	// The edges are re-encoded with the profile, level and pixel format of the source, so the
+	// parameter sets stay compatible with the copied segment. All segments use the same
+	// container and therefore the same timebase.
+	stream := fmt.Sprintf("0:v:%d", video.stream)
+	reencode := []string{"-c:v", encoderFor(video.codec)}
+	if video.pixfmt != "" {
+		reencode = append(reencode, "-pix_fmt", video.pixfmt)
+	}
+	settings, err := encoderArgs(encoderFor(video.codec), source)
+	if err != nil {
+		return err
+	}
+	reencode = append(reencode, settings...)
+
+	type segment struct {
+		start, end float64
+		args       []string
+	}
+	segments := []segment{
+		{start, first, reencode},
+		{first, last, []string{"-c:v", "copy"}},
+		{last, end, reencode},
+	}
+
+	files := []string{}
+	for i, s := range segments {
+		if s.end-s.start <= 1e-6 {
+			continue
+		}
+		file := filepath.Join(dir, fmt.Sprintf("%d%s", i, ext))
+		args := []string{"-ss", seconds(s.start), "-i", video.filename, "-t", seconds(s.end - s.start), "-map", stream}
+		args = append(append(args, s.args...), file)
+		if err := ffmpeg(args...); err != nil {
+			return err
+		}
+		files = append(files, file)
+	}
+
+	list, err := concatList(dir, files)
+	if err != nil {
+		return err
+	}
+
+	// Data streams, e.g. tmcd timecode tracks, cannot be cut without re-encoding and are dropped.
+	maps := []string{}
+	if len(video.audio) > 0 {
+		maps = append(maps, "-map", "0:a")
+	}
+	if len(video.subtitles) > 0 {
+		maps = append(maps, "-map", "0:s")
+	}
+	if len(video.attachments) > 0 {
+		maps = append(maps, "-map", "0:t")
+	}
+
+	if len(maps) == 0 {
+		return ffmpeg("-f", "concat", "-safe", "0", "-i", list, "-map", "0:v", "-c", "copy", output)
+	}
+
+	// The other streams are written in the container of the output, which has to hold them anyway,
+	// e.g. mov_text subtitles of MP4 files. NUT is used if the output has no extension.
+	streams := filepath.Join(dir, "streams"+filepath.Ext(output))
+	format := []string{}
+	if filepath.Ext(output) == "" {
+		format = []string{"-f", "nut"}
+	}
+
+	// Output seeking discards packets outside the range without decoding, which keeps
+	// the copied audio and subtitles aligned with the trimmed video.
+	args := []string{"-i", video.filename, "-ss", seconds(start), "-t", seconds(end - start)}
+	args = append(args, maps...)
+	args = append(args, "-c", "copy")
+	args = append(args, format...)
+	if err := ffmpeg(append(args, streams)...); err != nil {
+		return err
+	}
+
+	return ffmpeg(
+		"-f", "concat",
+		"-safe", "0",
+		"-i", list,
+		"-i", streams,
+		"-map", "0:v",
+		"-map", "1",
+		"-c", "copy",
+		output,
+	)
+}
+
+// Writes a concat demuxer file list of the given files to the given directory and returns its path.
+func concatList(dir string, files []string) (string, error) {
+	builder := strings.Builder{}
+	for _, file := range files {
+		path, err := filepath.Abs(file)
+		if err != nil {
+			return "", err
+		}
+		// Single quotes are escaped by closing the quote, escaping the quote and reopening it.
+		builder.WriteString(fmt.Sprintf("file '%s'\n", strings.ReplaceAll(path, "'", `'\''`)))
+	}
+
+	list := filepath.Join(dir, "list.txt")
+	if err := os.WriteFile(list, []byte(builder.String()), 0644); err != nil {
+		return "", err
+	}
+	return list, nil
+}
+
+// Optional parameters for Concat. Unset values default to those of the first input.
+type ConcatOptions struct {
+	Width       int     // Width of the re-encoded video. Inputs are scaled and padded to fit.
+	Height      int     // Height of the re-encoded video.
+	FPS         float64 // Frame rate of the re-encoded video.
+	PixelFormat string  // Pixel format of the re-encoded video. Default yuv420p.
+	Codec       string  // Video encoder used when re-encoding.
+	SampleRate  int     // Sample rate of the re-encoded audio.
+	Channels    int     // Number of channels of the re-encoded audio. Default 2.
+	AudioCodec  string  // Audio encoder used when re-encoding. Default aac.
+	Reencode    bool    // Always re-encode, even if all inputs have matching parameters.
+}
+
+// Video and audio stream parameters of a Concat input.
+type concatInput struct {
+	video  *Video              // First video stream.
+	stream map[string]string   // ffprobe data of the first video stream.
+	audio  []map[string]string // ffprobe data of the audio streams.
+}
+
+// Concatenates the given videos into the output file. If the codecs and parameters of all inputs
+// match, the inputs are joined with the concat demuxer using stream copy, keeping all streams.
+// Otherwise the first video and audio stream of every input are normalized to the same size,
+// frame rate, pixel format and sample rate and re-encoded. Inputs without audio are filled with silence.
+func Concat(inputs []string, output string, options *ConcatOptions) error {
+	if len(inputs) == 0 {
+		return fmt.Errorf("vidio: no inputs to concatenate")
+	}
+
+	if options == nil {
+		options = &ConcatOptions{}
+	}
+
+	probes := make([]concatInput, len(inputs))
+	for i, input := range inputs {
+		video, err := NewVideo(input)
+		if err != nil {
+			return err
+		}
+		// The extradata hash identifies the parameter sets (e.g. SPS/PPS) of the stream.
+		data, err := probe(
+			input,
+			"-show_streams",
+			"-show_data_hash", "MD5",
+			"-select_streams", fmt.Sprintf("v:%d", video.stream),
+		)
+		if err != nil {
+			return err
+		}
+		stream := map[string]string{}
+		if len(data) > 0 {
+			stream = data[0]
+		}
+		audio, err := ffprobe(input, "a")
+		if err != nil {
+			return err
+		}
+		probes[i] = concatInput{video: video, stream: stream, audio: audio}
+	}
+
+	if !options.Reencode && concatCompatible(probes) {
+		dir, err := os.MkdirTemp("", "vidio")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		list, err := concatList(dir, inputs)
+		if err != nil {
+			return err
+		}
+
+		return ffmpeg("-f", "concat", "-safe", "0", "-i", list, "-map", "0", "-c", "copy", output)
+	}
+
+	return ffmpeg(concatReencodeArgs(probes, output, options)...)
+}
+
+// Returns true if all inputs can be joined with stream copy. Besides the frame parameters, the
+// profile, level, time base and parameter sets of the video streams must match, since containers
+// like MP4 store only one set of parameter sets for the whole stream.
+func concatCompatible(inputs []concatInput) bool {
+	first := inputs[0]
+	for _, input := range inputs[1:] {
+		a, b := first.video, input.video
+		if a.codec != b.codec || a.width != b.width || a.height != b.height || a.pixfmt != b.pixfmt || a.fps != b.fps {
+			return false
+		}
+		for _, key := range []string{"profile", "level", "time_base", "extradata_hash"} {
+			if first.stream[key] != input.stream[key] {
+				return false
+			}
+		}
+		if len(first.audio) != len(input.audio) {
+			return false
+		}
+		for i := range first.audio {
+			for _, key := range []string{"codec_name", "sample_rate", "channels", "channel_layout", "sample_fmt"} {
+				if first.audio[i][key] != input.audio[i][key] {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// Returns the ffmpeg arguments normalizing and concatenating the inputs with the concat filter.
+func concatReencodeArgs(inputs []concatInput, output string, options *ConcatOptions) []string {
+	first := inputs[0].video
+
+	width, height, fps := options.Width, options.Height, options.FPS
+	if width == 0 {
+		width = first.width
+	}
+	if height == 0 {
+		height = first.height
+	}
+	if fps == 0 {
+		fps = first.fps
+	}
+	pixfmt := options.PixelFormat
+	if pixfmt == "" {
+		pixfmt = "yuv420p"
+	}
+	codec := options.Codec
+	if codec == "" {
+		codec = encoderFor(first.codec)
+	}
+	audiocodec := options.AudioCodec
+	if audiocodec == "" {
+		audiocodec = "aac"
+	}
+
+	audio := false
+	samplerate, channels := options.SampleRate, options.Channels
+	for _, input := range inputs {
+		if len(input.audio) > 0 {
+			audio = true
+			if samplerate == 0 {
+				samplerate = int(parse(input.audio[0]["sample_rate"]))
+			}
+			if channels == 0 {
+				channels = int(parse(input.audio[0]["channels"]))
+			}
+		}
+	}
+	if samplerate == 0 {
+		samplerate = 48000
+	}
+	if channels == 0 {
+		channels = 2
+	}
+	layout := channelLayout(channels)
+
+	args := []string{}
+	for _, input := range inputs {
+		args = append(args, "-i", input.video.filename)
+	}
+
+	filters := []string{}
+	concat := ""
+	silence := len(inputs)
+	for i, input := range inputs {
+		filters = append(filters, fmt.Sprintf(
+			"[%d:v:%d]scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=%s,format=%s[v%d]",
+			i, input.video.stream, width, height, width, height, strconv.FormatFloat(fps, 'f', -1, 64), pixfmt, i,
+		))
+		concat += fmt.Sprintf("[v%d]", i)
+
+		if !audio {
+			continue
+		}
+		source := fmt.Sprintf("%d:a:0", i)
+		if len(input.audio) == 0 {
+			// Generate silence for the duration of the input.
+			args = append(
+				args,
+				"-f", "lavfi",
+				"-t", seconds(input.video.duration),
+				"-i", fmt.Sprintf("anullsrc=r=%d:cl=%s", samplerate, layout),
+			)
+			source = fmt.Sprintf("%d:a", silence)
+			silence++
+		}
+		filters = append(filters, fmt.Sprintf(
+			"[%s]aresample=%d,aformat=sample_fmts=fltp:channel_layouts=%s[a%d]",
+			source, samplerate, layout, i,
+		))
+		concat += fmt.Sprintf("[a%d]", i)
+	}
+
+	streams := 0
+	if audio {
+		streams = 1
+	}
+	filters = append(filters, fmt.Sprintf("%sconcat=n=%d:v=1:a=%d[v]", concat, len(inputs), streams))
+	if audio {
+		filters[len(filters)-1] += "[a]"
+	}
+
+	args = append(args, "-filter_complex", strings.Join(filters, ";"), "-map", "[v]", "-c:v", codec)
+	if audio {
+		args = append(args, "-map", "[a]", "-c:a", audiocodec)
+	}
+
+	return append(args, output)
+}
+
+// Returns the ffmpeg channel layout name for the given number of channels.
+func channelLayout(channels int) string {
+	switch channels {
+	case 1:
+		return "mono"
+	case 2:
+		return "stereo"
+	case 6:
+		return "5.1"
+	case 8:
+		return "7.1"
+	default:
+		return fmt.Sprintf("%dc", channels)
+	}
+}
+
+// Selects streams of the input file for Remux or of a StreamFile.
+type StreamSelection struct {
+	Type     string // Stream type: v (video), a (audio), s (subtitle), d (data) or t (attachment).
+	Index    int    // Index among the matching streams of the given type. -1 selects all matching streams.
+	Language string // If set, only streams with this language tag (e.g. eng) match.
+	Codec    string // Encoder used to re-encode the selected streams. Default copy.
+	Default  bool   // Marks the selected streams as default. Clears the flag on other streams of the same type.
+	Forced   bool   // Marks the selected streams as forced.
+}
+
+// Remuxes the input file into the output file, e.g. to change the container. Only the streams
+// matching the selections are kept, in the order of the selections. Streams are copied unless an
+// encoder is given. If no selections are given, all streams are copied.
+func Remux(input, output string, selection []StreamSelection) error {
+	if !exists(input) {
+		return fmt.Errorf("vidio: file %s does not exist", input)
+	}
+
+	if len(selection) == 0 {
+		return ffmpeg("-i", input, "-map", "0", "-c", "copy", output)
+	}
+
+	streams, err := probeStreams(input)
+	if err != nil {
+		return err
+	}
+
+	args, _, err := selectionArgs(streams, selection, 0, 0, "")
+	if err != nil {
+		return err
+	}
+
+	return ffmpeg(append(append([]string{"-i", input}, args...), output)...)
+}
+
+// Returns the ffprobe data of all streams of the given file, keyed by stream type.
+func probeStreams(filename string) (map[string][]map[string]string, error) {
+	streams := map[string][]map[string]string{}
+	for _, c := range "vasdt" {
+		data, err := ffprobe(filename, string(c))
+		if err != nil {
+			return nil, err
+		}
+		streams[string(c)] = data
+	}
+	return streams, nil
+}
+
+// Returns the ffmpeg output arguments mapping the selected streams of the input with the given
+// index to the outputs starting at index "output", and the index of the next output. "streams"
+// holds the ffprobe data of all streams of the input, keyed by stream type. Audio streams without
+// an encoder are encoded with "acodec" if given, otherwise all such streams are copied.
+func selectionArgs(streams map[string][]map[string]string, selection []StreamSelection, input, output int, acodec string) ([]string, int, error) {
+	// Stream types where a selection sets the default flag.
+	defaults := map[string]bool{}
+	for _, s := range selection {
+		if s.Default {
+			defaults[s.Type] = true
+		}
+	}
+
+	args := []string{}
+	for _, s := range selection {
+		data, ok := streams[s.Type]
+		if !ok {
+			return nil, 0, fmt.Errorf("vidio: unsupported stream type %s", s.Type)
+		}
+
+		matches := []map[string]string{}
+		for _, stream := range data {
+			if s.Language == "" || stream["tag:language"] == s.Language {
+				matches = append(matches, stream)
+			}
+		}
+		if s.Index >= 0 {
+			if s.Index >= len(matches) {
+				return nil, 0, fmt.Errorf("vidio: no %s stream with index %d and language %q", s.Type, s.Index, s.Language)
+			}
+			matches = matches[s.Index : s.Index+1]
+		}
+
+		codec := s.Codec
+		if codec == "" {
+			if s.Type == "a" && acodec != "" {
+				codec = acodec
+			} else {
+				codec = "copy"
+			}
+		}
+
+		disposition := []string{}
+		if s.Default {
+			disposition = append(disposition, "default")
+		}
+		if s.Forced {
+			disposition = append(disposition, "forced")
+		}
+
+		for _, stream := range matches {
+			args = append(
+				args,
+				"-map", fmt.Sprintf("%d:%s", input, stream["index"]),
+				fmt.Sprintf("-c:%d", output), codec,
+			)
+			if len(disposition) > 0 {
+				args = append(args, fmt.Sprintf("-disposition:%d", output), strings.Join(disposition, "+"))
+			} else if defaults[s.Type] {
+				args = append(args, fmt.Sprintf("-disposition:%d", output), "0")
+			}
+			output++
+		}
+	}
+
+	return args, output, nil
+}
@@ -0,0 +1,332 @@
+package vidio
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Encoder settings of a VideoWriter.
+type encoderSettings struct {
+	preset  string            // Speed/compression trade-off, e.g. medium.
+	tune    string            // Content tuning, e.g. film.
+	profile string            // Profile, e.g. high.
+	level   string            // Level, e.g. 4.1.
+	keyint  int               // Maximum number of frames between keyframes. 0 uses the encoder default.
+	bframes int               // Maximum number of consecutive B-frames. 0 uses the encoder default, -1 disables them.
+	refs    int               // Number of reference frames. 0 uses the encoder default.
+	closed  bool              // Close every GOP, so no frame references frames before its keyframe.
+	fixed   bool              // Place keyframes exactly every keyint frames, without scene cut detection.
+	forced  bool              // Keyframes are forced at given frames and must be IDR frames.
+	params  map[string]string // Codec-private parameters.
+}
+
+// ffmpeg options of an encoder for each setting. Empty options are not supported by the encoder.
+// Options starting with "params:" are passed as the given codec-private parameter instead.
+// Options of boolean settings include their value as option=value. Settings whose option is
+// encoderDefault need no option since the encoder behaves that way by default.
+type encoderOptions struct {
+	preset   string // Option for the preset.
+	tune     string // Option for the tuning.
+	profile  string // Option for the profile.
+	level    string // Option for the level.
+	keyint   string // Option for the keyframe interval.
+	bframes  string // Option for the number of B-frames.
+	refs     string // Option for the number of reference frames.
+	closed   string // Option and value closing GOPs.
+	minint   string // Option for the minimum keyframe interval, set to keyint for fixed GOPs.
+	scenecut string // Option and value disabling scene cut keyframes for fixed GOPs.
+	forced   string // Option and value making forced keyframes IDR frames.
+	params   string // Option taking codec-private parameters as key=value pairs separated by colons.
+}
+
+// Option of settings the encoder applies without any option.
+const encoderDefault = "default"
+
+// Encoder options of the supported encoders.
+var encoderSettingOptions = map[string]encoderOptions{
+	"libx264": {
+		preset: "-preset", tune: "-tune", profile: "-profile:v", level: "-level:v",
+		keyint: "-g", bframes: "-bf", refs: "-refs", closed: "-flags=+cgop",
+		minint: "-keyint_min", scenecut: "-sc_threshold=0", forced: "-forced-idr=1", params: "-x264-params",
+	},
+	"libx265": {
+		preset: "-preset", tune: "-tune", profile: "-profile:v", level: "params:level-idc",
+		keyint: "-g", bframes: "params:bframes", refs: "params:ref", closed: "params:open-gop=0",
+		minint: "params:min-keyint", scenecut: "params:scenecut=0", forced: "-forced-idr=1", params: "-x265-params",
+	},
+	// Keyframes of VP9 and AV1 always start a closed GOP.
+	"libvpx-vp9": {
+		preset: "-deadline", tune: "-tune-content", profile: "-profile:v", keyint: "-g",
+		closed: encoderDefault, minint: "-keyint_min", scenecut: encoderDefault, forced: encoderDefault,
+	},
+	"libaom-av1": {
+		preset: "-cpu-used", tune: "-tune", profile: "-profile:v", keyint: "-g", closed: encoderDefault,
+		minint: "-keyint_min", scenecut: encoderDefault, forced: encoderDefault, params: "-aom-params",
+	},
+	"libsvtav1": {
+		preset: "-preset", tune: "params:tune", profile: "-profile:v", level: "-level",
+		keyint: "-g", closed: "params:irefresh-type=2", minint: encoderDefault, scenecut: "params:scd=0",
+		forced: encoderDefault, params: "-svtav1-params",
+	},
+	"mpeg4": {
+		profile: "-profile:v", keyint: "-g", bframes: "-bf", closed: "-flags=+cgop",
+		minint: encoderDefault, scenecut: "-sc_threshold=1000000000", forced: encoderDefault,
+	},
+}
+
+// Options of encoders without specific support, e.g. hardware encoders.
+// Codec-private parameters are passed as separate options.
+var defaultEncoderOptions = encoderOptions{
+	preset: "-preset", tune: "-tune", profile: "-profile:v", level: "-level:v",
+	keyint: "-g", bframes: "-bf", refs: "-refs", closed: "-flags=+cgop",
+	minint: "-keyint_min", scenecut: "-sc_threshold=0", forced: encoderDefault,
+}
+
+// Maps the quality parameter (0:best, 1:worst) to a constant quality option of an encoder.
+type qualityModel struct {
+	option string   // Constant quality option, e.g. -crf.
+	best   int      // Value of the option for the best quality.
+	worst  int      // Value of the option for the worst quality.
+	extra  []string // Extra options enabling constant quality mode.
+}
+
+// Quality models of encoders with a constant rate factor.
+var qualityModels = map[string]qualityModel{
+	"libx264":    {option: "-crf", best: 0, worst: 51},
+	"libx265":    {option: "-crf", best: 0, worst: 51},
+	"libvpx":     {option: "-crf", best: 4, worst: 63, extra: []string{"-b:v", "10M"}}, // VP8 needs a bitrate as the ceiling.
+	"libvpx-vp9": {option: "-crf", best: 0, worst: 63, extra: []string{"-b:v", "0"}},   // Constant quality needs a zero bitrate.
+	"libaom-av1": {option: "-crf", best: 0, worst: 63, extra: []string{"-b:v", "0"}},
+	"libsvtav1":  {option: "-crf", best: 1, worst: 63},
+}
+
+// Quality model of all other encoders, e.g. mpeg4 and mjpeg.
+var defaultQualityModel = qualityModel{option: "-qscale:v", best: 1, worst: 31}
+
+// Returns the ffmpeg output arguments encoding with the given quality between 0 (best) and 1 (worst),
+// mapped to the quality scale of the given encoder.
+func qualityArgs(codec string, quality float64) []string {
+	if encoder, ok := encoders[codec]; ok {
+		codec = encoder
+	}
+	model, ok := qualityModels[codec]
+	if !ok {
+		model = defaultQualityModel
+	}
+
+	value := model.best + int(quality*float64(model.worst-model.best))
+	return append([]string{model.option, fmt.Sprintf("%d", value)}, model.extra...)
+}
+
+// A setting passed to an encoder.
+type encoderSetting struct {
+	name   string // Name of the setting for error messages.
+	option string // ffmpeg option of the setting.
+	value  string // Value of the setting. Empty if not set.
+}
+
+// Returns the ffmpeg output arguments applying the given settings to the given encoder.
+// Returns an error if the encoder does not support one of the settings.
+func encoderArgs(codec string, settings encoderSettings) ([]string, error) {
+	if encoder, ok := encoders[codec]; ok {
+		codec = encoder
+	}
+	options, ok := encoderSettingOptions[codec]
+	if !ok {
+		options = defaultEncoderOptions
+	}
+
+	bframes := ""
+	if settings.bframes < 0 {
+		bframes = "0"
+	} else if settings.bframes > 0 {
+		bframes = fmt.Sprintf("%d", settings.bframes)
+	}
+
+	minint := ""
+	if settings.fixed {
+		if settings.keyint <= 0 {
+			return nil, fmt.Errorf("vidio: a fixed GOP requires a keyframe interval")
+		}
+		minint = fmt.Sprintf("%d", settings.keyint)
+	}
+
+	values := []encoderSetting{
+		{"presets", options.preset, settings.preset},
+		{"tunings", options.tune, settings.tune},
+		{"profiles", options.profile, settings.profile},
+		{"levels", options.level, settings.level},
+		{"keyframe intervals", options.keyint, positive(settings.keyint)},
+		{"B-frames", options.bframes, bframes},
+		{"reference frames", options.refs, positive(settings.refs)},
+		{"fixed GOPs", options.minint, minint},
+	}
+
+	// Boolean settings take the value from their option.
+	flags := []struct {
+		name    string // Name of the setting for error messages.
+		option  string // ffmpeg option and value of the setting.
+		enabled bool   // True if the setting is enabled.
+	}{
+		{"closed GOPs", options.closed, settings.closed},
+		{"fixed GOPs", options.scenecut, settings.fixed},
+		{"forced IDR frames", options.forced, settings.forced},
+	}
+	for _, flag := range flags {
+		if !flag.enabled {
+			continue
+		}
+		if flag.option == "" {
+			return nil, fmt.Errorf("vidio: encoder %s does not support %s", codec, flag.name)
+		}
+		if flag.option == encoderDefault {
+			continue
+		}
+		pair := strings.SplitN(flag.option, "=", 2)
+		values = append(values, encoderSetting{flag.name, pair[0], pair[1]})
+	}
+
+	args := []string{}
+	params := []string{}
+	for _, setting := range values {
+		if setting.value == "" {
+			continue
+		}
+		switch {
+		case setting.option == "":
+			return nil, fmt.Errorf("vidio: encoder %s does not support %s", codec, setting.name)
+		case setting.option == encoderDefault:
+			continue
+		case strings.HasPrefix(setting.option, "params:"):
+			params = append(params, strings.TrimPrefix(setting.option, "params:")+"="+setting.value)
+		default:
+			args = append(args, setting.option, setting.value)
+		}
+	}
+
+	// Codec-private parameters come last to override the settings above.
+	for _, key := range sortedKeys(settings.params) {
+		if options.params == "" {
+			args = append(args, "-"+key, settings.params[key])
+		} else {
+			params = append(params, key+"="+settings.params[key])
+		}
+	}
+	if len(params) > 0 {
+		args = append(args, options.params, strings.Join(params, ":"))
+	}
+
+	return args, nil
+}
+
+// Formats a positive integer setting. Returns an empty string for unset settings.
+func positive(value int) string {
+	if value <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d", value)
+}
+
+// Returns the ffmpeg output arguments and codec-private parameters constraining the bitrate with the
+// rate control buffer. With "cbr", the bitrate is held constant and the buffer defaults to one second
+// of video. libx264 and libx265 ignore -minrate and need their own options for constant bitrate.
+// Otherwise the buffer defaults to twice the maximum bitrate.
+func rateArgs(codec string, bitrate, maxrate, bufsize int, cbr bool) ([]string, map[string]string) {
+	if encoder, ok := encoders[codec]; ok {
+		codec = encoder
+	}
+
+	args := []string{}
+	var params map[string]string
+	if cbr {
+		maxrate = bitrate
+		if bufsize == 0 {
+			bufsize = bitrate
+		}
+		args = append(args, "-minrate", fmt.Sprintf("%d", bitrate))
+		switch codec {
+		case "libx264":
+			args = append(args, "-nal-hrd", "cbr")
+		case "libx265":
+			params = map[string]string{"strict-cbr": "1"}
+		}
+	} else if maxrate > 0 && bufsize == 0 {
+		bufsize = 2 * maxrate
+	}
+
+	if maxrate > 0 {
+		args = append(args, "-maxrate", fmt.Sprintf("%d", maxrate))
+	}
+	if bufsize > 0 {
+		args = append(args, "-bufsize", fmt.Sprintf("%d", bufsize))
+	}
+	return args, params
+}
+
+// Returns true if the given encoder supports two-pass encoding with ffmpeg's -pass option.
+func twoPassSupported(codec string) bool {
+	if encoder, ok := encoders[codec]; ok {
+		codec = encoder
+	}
+	switch codec {
+	case "libx264", "libx265", "libvpx-vp9", "libvpx", "libaom-av1", "mpeg4", "msmpeg4", "mpeg2video":
+		return true
+	default:
+		return false
+	}
+}
+
+// Returns the ffmpeg output arguments and codec-private parameters selecting the given pass of
+// two-pass encoding with the pass log file prefix "log". libx265 only reads its own parameters.
+func passArgs(codec string, pass int, log string) ([]string, map[string]string) {
+	if encoder, ok := encoders[codec]; ok {
+		codec = encoder
+	}
+	if codec == "libx265" {
+		return nil, map[string]string{"pass": fmt.Sprintf("%d", pass), "stats": log + ".log"}
+	}
+	return []string{"-pass", fmt.Sprintf("%d", pass), "-passlogfile", log}, nil
+}
+
+// Returns a copy of "params" with the given extra parameters added.
+func mergeParams(params, extra map[string]string) map[string]string {
+	if len(extra) == 0 {
+		return params
+	}
+	merged := map[string]string{}
+	for key, value := range params {
+		merged[key] = value
+	}
+	for key, value := range extra {
+		merged[key] = value
+	}
+	return merged
+}
+
+// Returns the video bitrate in bits/s that results in a file of about "size" bytes for a video
+// of the given duration in seconds with audio at "audioBitrate" bits/s. About 2% of the size is
+// reserved for the container overhead. Returns 0 if the size is too small.
+func BitrateForSize(size int64, duration float64, audioBitrate int) int {
+	if duration <= 0 {
+		return 0
+	}
+	bits := float64(size) * 8 * 0.98
+	bitrate := int(bits/duration) - audioBitrate
+	if bitrate < 0 {
+		return 0
+	}
+	return bitrate
+}
+
+// Returns the -force_key_frames expression forcing keyframes with the given expression or,
+// if empty, at the given frame indices.
+func keyframeExpression(expression string, frames []int) string {
+	if expression != "" {
+		return expression
+	}
+	terms := make([]string, len(frames))
+	for i, frame := range frames {
+		terms[i] = fmt.Sprintf("eq(n,%d)", frame)
+	}
+	return "expr:" + strings.Join(terms, "+")
+}
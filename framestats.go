@@ -0,0 +1,170 @@
+package vidio
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os/exec"
+	"strings"
+)
+
+// Per-frame statistics of a video stream as reported by ffprobe.
+type FrameStat struct {
+	Frame    int     // Zero-indexed frame number in presentation order.
+	PictType string  // Picture type: I, P, B, S, SI, SP or BI.
+	Keyframe bool    // True if the frame is a keyframe.
+	Size     int     // Size of the compressed packet in bytes.
+	PTS      float64 // Presentation timestamp in seconds.
+	DTS      float64 // Decode timestamp in seconds.
+	Duration float64 // Frame duration in seconds.
+	Corrupt  bool    // True if the packet is flagged as corrupt.
+}
+
+// Bitrate of a video over a time window.
+type BitrateSample struct {
+	Start   float64 // Start of the window in seconds.
+	Bitrate float64 // Average bitrate within the window in bits/s.
+}
+
+// Streams per-frame statistics of the video to the callback, in presentation order, without
+// decoding frames to RGBA. Iteration stops early if the callback returns false.
+func (video *Video) FrameStats(callback func(stat FrameStat) bool) error {
+	cmd := exec.Command(
+		"ffprobe",
+		"-select_streams", fmt.Sprintf("v:%d", video.stream),
+		"-show_packets",
+		"-show_frames",
+		"-show_entries", strings.Join([]string{
+			"packet=pos,dts_time,flags",
+			"frame=pkt_pos,pict_type,key_frame,pkt_size,pts_time,pkt_dts_time,best_effort_timestamp_time,duration_time,pkt_duration_time",
+		}, ":"),
+		"-print_format", "compact",
+		"-loglevel", "quiet",
+		video.filename,
+	)
+
+	pipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// Packets are printed before the frames they decode to. Frames are matched to
+	// their packets by byte position to get the decode timestamp and corrupt flag.
+	packets := map[string]map[string]string{}
+	frame := 0
+	stopped := false
+
+	scanner := bufio.NewScanner(pipe)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		data := parseCompact(line)
+		switch {
+		case strings.HasPrefix(line, "packet"):
+			packets[data["pos"]] = data
+		case strings.HasPrefix(line, "frame"):
+			packet := packets[data["pkt_pos"]]
+			delete(packets, data["pkt_pos"])
+
+			stat := parseFrameStat(data, packet)
+			stat.Frame = frame
+			frame++
+
+			if !callback(stat) {
+				stopped = true
+			}
+		}
+		if stopped {
+			break
+		}
+	}
+
+	if stopped {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+
+	return cmd.Wait()
+}
+
+// Parses the frame statistics from the ffprobe output of a frame and the packet it was decoded from.
+func parseFrameStat(frame, packet map[string]string) FrameStat {
+	// Returns the first available value among the given keys.
+	value := func(data map[string]string, keys ...string) string {
+		for _, key := range keys {
+			if v, ok := data[key]; ok && v != "N/A" {
+				return v
+			}
+		}
+		return ""
+	}
+
+	stat := FrameStat{
+		PictType: frame["pict_type"],
+		Keyframe: frame["key_frame"] == "1",
+		Size:     int(parse(frame["pkt_size"])),
+		PTS:      parse(value(frame, "pts_time", "best_effort_timestamp_time")),
+		DTS:      parse(value(frame, "pkt_dts_time")),
+		Duration: parse(value(frame, "duration_time", "pkt_duration_time")),
+	}
+	if packet != nil {
+		if dts := value(packet, "dts_time"); dts != "" {
+			stat.DTS = parse(dts)
+		}
+		// Packet flags are printed as "K", "D" and "C" for keyframe, discard and corrupt.
+		stat.Corrupt = strings.Contains(packet["flags"], "C")
+	}
+	return stat
+}
+
+// Computes the bitrate of the given frames over consecutive windows of the given size in seconds.
+// Windows start at the earliest presentation timestamp. The last window is averaged over the
+// time it actually covers.
+func BitrateSeries(stats []FrameStat, window float64) []BitrateSample {
+	if len(stats) == 0 || window <= 0 {
+		return nil
+	}
+
+	start, end := math.Inf(1), math.Inf(-1)
+	for _, stat := range stats {
+		start = math.Min(start, stat.PTS)
+		end = math.Max(end, stat.PTS+stat.Duration)
+	}
+
+	// Allow for rounding errors in the timestamps so that exact multiples of the window do not add a window.
+	count := int(math.Ceil((end-start)/window - 1e-9))
+	if count == 0 {
+		count = 1
+	}
+
+	bits := make([]float64, count)
+	for _, stat := range stats {
+		index := int((stat.PTS - start) / window)
+		if index >= count {
+			index = count - 1
+		}
+		bits[index] += float64(stat.Size * 8)
+	}
+
+	samples := make([]BitrateSample, count)
+	for i := range samples {
+		samples[i].Start = start + float64(i)*window
+		duration := math.Min(window, end-samples[i].Start)
+		if duration > 0 {
+			samples[i].Bitrate = bits[i] / duration
+		}
+	}
+
+	return samples
+}
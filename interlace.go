@@ -0,0 +1,119 @@
+package vidio
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// Results of the ffmpeg idet (interlace detection) filter.
+type Interlace struct {
+	TFF             int  // Frames detected as top field first.
+	BFF             int  // Frames detected as bottom field first.
+	Progressive     int  // Frames detected as progressive.
+	Undetermined    int  // Frames that could not be classified.
+	RepeatedNeither int  // Frames without a repeated field.
+	RepeatedTop     int  // Frames where the top field is repeated from the previous frame.
+	RepeatedBottom  int  // Frames where the bottom field is repeated from the previous frame.
+	Interlaced      bool // True if most classified frames are interlaced.
+	Telecined       bool // True if the repeated field pattern indicates 3:2 pulldown.
+}
+
+// Deinterlacing modes supported by SetDeinterlace.
+var deinterlaceFilters = map[string]string{
+	"yadif":       "yadif=mode=send_frame:parity=auto:deint=all",
+	"yadif_field": "yadif=mode=send_field:parity=auto:deint=all",
+	"bwdif":       "bwdif=mode=send_frame:parity=auto:deint=all",
+	"bwdif_field": "bwdif=mode=send_field:parity=auto:deint=all",
+	"ivtc":        "fieldmatch,yadif=deint=interlaced,decimate",
+}
+
+// Field order of the video stream from ffprobe: progressive, tt, bb, tb, bt or unknown.
+// "tt" and "tb" are top field first, "bb" and "bt" are bottom field first.
+func (video *Video) FieldOrder() string {
+	return video.fieldorder
+}
+
+// Deinterlacing mode applied during decoding. Empty if disabled.
+func (video *Video) Deinterlace() string {
+	return video.deinterlace
+}
+
+// Sets the deinterlacing mode applied during decoding. Must be called before the first Read().
+//
+//	yadif, bwdif:             Deinterlace, producing one frame per frame.
+//	yadif_field, bwdif_field: Deinterlace, producing one frame per field (double frame rate).
+//	ivtc:                     Inverse telecine, removing 3:2 pulldown (4/5 of the frame rate).
+//
+// An empty string disables deinterlacing. Note that Frames() and FPS() still describe the source
+// stream, so field rate deinterlacing produces twice as many frames and inverse telecine fewer.
+func (video *Video) SetDeinterlace(mode string) error {
+	if _, ok := deinterlaceFilters[mode]; !ok && mode != "" {
+		return fmt.Errorf("vidio: unsupported deinterlacing mode %s", mode)
+	}
+	video.deinterlace = mode
+	return nil
+}
+
+// Analyzes the first "frames" frames of the video with the ffmpeg idet filter to determine
+// whether the content is actually interlaced or telecined. If frames is 0, the entire video is analyzed.
+func (video *Video) DetectInterlace(frames int) (*Interlace, error) {
+	command := []string{
+		"-hide_banner",
+		"-nostats",
+		"-i", video.filename,
+		"-map", fmt.Sprintf("0:v:%d", video.stream),
+		"-vf", "idet",
+		"-an",
+	}
+	if frames > 0 {
+		command = append(command, "-frames:v", fmt.Sprintf("%d", frames))
+	}
+	command = append(command, "-f", "null", "-")
+
+	// idet reports its statistics to stderr.
+	output, err := exec.Command("ffmpeg", command...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("vidio: failed to run interlace detection: %w", err)
+	}
+
+	return parseIdet(string(output)), nil
+}
+
+// Parses the statistics printed by the idet filter. Multi frame detection is used
+// since it is more reliable than single frame detection.
+func parseIdet(output string) *Interlace {
+	interlace := &Interlace{}
+
+	count := func(line, key string) int {
+		regex := regexp.MustCompile(key + `:\s*(\d+)`)
+		match := regex.FindStringSubmatch(line)
+		if len(match) < 2 {
+			return 0
+		}
+		return int(parse(match[1]))
+	}
+
+	for _, line := range strings.Split(output, "\n") {
+		switch {
+		case strings.Contains(line, "Multi frame detection:"):
+			interlace.TFF = count(line, "TFF")
+			interlace.BFF = count(line, "BFF")
+			interlace.Progressive = count(line, "Progressive")
+			interlace.Undetermined = count(line, "Undetermined")
+		case strings.Contains(line, "Repeated Fields:"):
+			interlace.RepeatedNeither = count(line, "Neither")
+			interlace.RepeatedTop = count(line, "Top")
+			interlace.RepeatedBottom = count(line, "Bottom")
+		}
+	}
+
+	// 3:2 pulldown repeats a field in 2 out of every 5 frames.
+	repeated := interlace.RepeatedTop + interlace.RepeatedBottom
+	total := repeated + interlace.RepeatedNeither
+	interlace.Telecined = total > 0 && float64(repeated)/float64(total) > 0.15
+	interlace.Interlaced = !interlace.Telecined && interlace.TFF+interlace.BFF > interlace.Progressive
+
+	return interlace
+}
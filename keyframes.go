@@ -0,0 +1,151 @@
+package vidio
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// A keyframe of a video stream.
+type Keyframe struct {
+	Frame     int     // Zero-indexed frame number in presentation order.
+	Timestamp float64 // Presentation timestamp in seconds.
+}
+
+// Group of pictures (GOP) statistics of a video stream. GOP lengths are in frames.
+type GOPStats struct {
+	Count      int     // Number of GOPs.
+	Average    float64 // Average GOP length.
+	Min        int     // Shortest GOP length.
+	Max        int     // Longest GOP length.
+	OpenGOPs   int     // Number of open GOPs, which contain frames referencing the previous GOP.
+	ClosedGOPs int     // Number of closed GOPs.
+}
+
+// Timing and flags of a compressed packet, in decode order.
+type packetInfo struct {
+	pts      float64 // Presentation timestamp in seconds.
+	dts      float64 // Decode timestamp in seconds.
+	keyframe bool    // True if the packet is a keyframe.
+}
+
+// Returns the keyframes of the video in presentation order. The keyframe index is built from
+// the packet flags reported by ffprobe without decoding, and cached on the video.
+func (video *Video) Keyframes() ([]Keyframe, error) {
+	if err := video.indexKeyframes(); err != nil {
+		return nil, err
+	}
+	return video.keyframes, nil
+}
+
+// Returns the GOP statistics of the video, computed from the keyframe index.
+func (video *Video) GOPStats() (*GOPStats, error) {
+	if err := video.indexKeyframes(); err != nil {
+		return nil, err
+	}
+	return video.gop, nil
+}
+
+// Reads the packet flags with ffprobe and builds the keyframe index and GOP statistics.
+func (video *Video) indexKeyframes() error {
+	if video.gop != nil {
+		return nil
+	}
+
+	data, err := probe(
+		video.filename,
+		"-select_streams", fmt.Sprintf("v:%d", video.stream),
+		"-show_entries", "packet=pts_time,dts_time,flags",
+	)
+	if err != nil {
+		return fmt.Errorf("vidio: failed to read packets of %s: %w", video.filename, err)
+	}
+
+	packets := make([]packetInfo, 0, len(data))
+	for _, packet := range data {
+		if _, ok := packet["flags"]; !ok {
+			continue
+		}
+		packets = append(packets, parsePacketInfo(packet))
+	}
+
+	video.keyframes, video.gop = indexPackets(packets)
+	return nil
+}
+
+// Parses the timestamps and flags of a packet from the ffprobe output.
+// Packets without a presentation timestamp use their decode timestamp.
+func parsePacketInfo(data map[string]string) packetInfo {
+	packet := packetInfo{
+		dts:      parse(data["dts_time"]),
+		keyframe: strings.Contains(data["flags"], "K"),
+	}
+	if pts, ok := data["pts_time"]; ok && pts != "N/A" {
+		packet.pts = parse(pts)
+	} else {
+		packet.pts = packet.dts
+	}
+	return packet
+}
+
+// Builds the keyframe index and GOP statistics from packets in decode order.
+func indexPackets(packets []packetInfo) ([]Keyframe, *GOPStats) {
+	// Frame numbers are assigned in presentation order.
+	order := make([]int, len(packets))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return packets[order[i]].pts < packets[order[j]].pts
+	})
+
+	keyframes := []Keyframe{}
+	for frame, index := range order {
+		if packets[index].keyframe {
+			keyframes = append(keyframes, Keyframe{Frame: frame, Timestamp: packets[index].pts})
+		}
+	}
+
+	gop := &GOPStats{Count: len(keyframes)}
+	for i, keyframe := range keyframes {
+		length := len(packets) - keyframe.Frame
+		if i+1 < len(keyframes) {
+			length = keyframes[i+1].Frame - keyframe.Frame
+		}
+		if i == 0 || length < gop.Min {
+			gop.Min = length
+		}
+		if length > gop.Max {
+			gop.Max = length
+		}
+		gop.Average += float64(length)
+	}
+	if gop.Count > 0 {
+		gop.Average /= float64(gop.Count)
+	}
+
+	// A GOP is open if a frame decoded after its keyframe is presented before it,
+	// meaning that frame references the previous GOP.
+	for i, packet := range packets {
+		if !packet.keyframe {
+			continue
+		}
+		open := false
+		for _, next := range packets[i+1:] {
+			if next.keyframe {
+				break
+			}
+			if next.pts < packet.pts {
+				open = true
+				break
+			}
+		}
+		if open {
+			gop.OpenGOPs++
+		} else {
+			gop.ClosedGOPs++
+		}
+	}
+
+	return keyframes, gop
+}
@@ -0,0 +1,211 @@
+package vidio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// Matroska element IDs used by the stream writer.
+// https://www.matroska.org/technical/elements.html.
+const (
+	mkvEBML               = 0x1A45DFA3
+	mkvEBMLVersion        = 0x4286
+	mkvEBMLReadVersion    = 0x42F7
+	mkvEBMLMaxIDLength    = 0x42F2
+	mkvEBMLMaxSizeLength  = 0x42F3
+	mkvDocType            = 0x4282
+	mkvDocTypeVersion     = 0x4287
+	mkvDocTypeReadVersion = 0x4285
+	mkvSegment            = 0x18538067
+	mkvInfo               = 0x1549A966
+	mkvTimestampScale     = 0x2AD7B1
+	mkvMuxingApp          = 0x4D80
+	mkvWritingApp         = 0x5741
+	mkvTracks             = 0x1654AE6B
+	mkvTrackEntry         = 0xAE
+	mkvTrackNumber        = 0xD7
+	mkvTrackUID           = 0x73C5
+	mkvTrackType          = 0x83
+	mkvFlagLacing         = 0x9C
+	mkvCodecID            = 0x86
+	mkvCodecPrivate       = 0x63A2
+	mkvVideo              = 0xE0
+	mkvPixelWidth         = 0xB0
+	mkvPixelHeight        = 0xBA
+	mkvColourSpace        = 0x2EB524
+	mkvCluster            = 0x1F43B675
+	mkvTimestamp          = 0xE7
+	mkvSimpleBlock        = 0xA3
+)
+
+// Size marking an element whose size is unknown, used for live streams.
+var mkvUnknownSize = []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+// Matroska codec IDs of the codecs supported by the stream writer.
+var mkvCodecs = map[string]string{
+	"h264":       "V_MPEG4/ISO/AVC",
+	"hevc":       "V_MPEGH/ISO/HEVC",
+	"vp8":        "V_VP8",
+	"vp9":        "V_VP9",
+	"av1":        "V_AV1",
+	"mpeg4":      "V_MPEG4/ISO/ASP",
+	"mjpeg":      "V_MJPEG",
+	"rawvideo":   "V_UNCOMPRESSED",
+	"prores":     "V_PRORES",
+	"ffv1":       "V_FFV1",
+	"mpeg2video": "V_MPEG2",
+}
+
+// Writes a live Matroska stream with a single video track. Used to pass timestamped
+// packets or frames to ffmpeg over a pipe, since raw formats carry no timestamps.
+// Timestamps are in microseconds.
+type matroska struct {
+	writer  io.Writer // Destination of the stream.
+	cluster int64     // Timestamp of the current cluster. -1 if no cluster has been started.
+}
+
+// Writes the Matroska header and track description for a video track with the given codec.
+// "private" is the codec private data (extradata) and "colourspace" the FourCC of raw frames.
+func newMatroska(writer io.Writer, codec string, width, height int, private, colourspace []byte) (*matroska, error) {
+	id, ok := mkvCodecs[codec]
+	if !ok {
+		return nil, fmt.Errorf("vidio: codec %s is not supported", codec)
+	}
+
+	header := ebmlElement(mkvEBML,
+		ebmlUint(mkvEBMLVersion, 1),
+		ebmlUint(mkvEBMLReadVersion, 1),
+		ebmlUint(mkvEBMLMaxIDLength, 4),
+		ebmlUint(mkvEBMLMaxSizeLength, 8),
+		ebmlElement(mkvDocType, []byte("matroska")),
+		ebmlUint(mkvDocTypeVersion, 4),
+		ebmlUint(mkvDocTypeReadVersion, 2),
+	)
+
+	video := [][]byte{
+		ebmlUint(mkvPixelWidth, uint64(width)),
+		ebmlUint(mkvPixelHeight, uint64(height)),
+	}
+	if len(colourspace) > 0 {
+		video = append(video, ebmlElement(mkvColourSpace, colourspace))
+	}
+
+	track := [][]byte{
+		ebmlUint(mkvTrackNumber, 1),
+		ebmlUint(mkvTrackUID, 1),
+		ebmlUint(mkvTrackType, 1), // Video.
+		ebmlUint(mkvFlagLacing, 0),
+		ebmlElement(mkvCodecID, []byte(id)),
+	}
+	if len(private) > 0 {
+		track = append(track, ebmlElement(mkvCodecPrivate, private))
+	}
+	track = append(track, ebmlElement(mkvVideo, video...))
+
+	segment := [][]byte{
+		ebmlElement(mkvInfo,
+			ebmlUint(mkvTimestampScale, 1000), // Microseconds.
+			ebmlElement(mkvMuxingApp, []byte("vidio")),
+			ebmlElement(mkvWritingApp, []byte("vidio")),
+		),
+		ebmlElement(mkvTracks, ebmlElement(mkvTrackEntry, track...)),
+	}
+
+	buffer := bytes.Buffer{}
+	buffer.Write(header)
+	buffer.Write(ebmlID(mkvSegment))
+	buffer.Write(mkvUnknownSize)
+	for _, element := range segment {
+		buffer.Write(element)
+	}
+
+	if _, err := writer.Write(buffer.Bytes()); err != nil {
+		return nil, err
+	}
+
+	return &matroska{writer: writer, cluster: -1}, nil
+}
+
+// Writes a block with the given timestamp in microseconds. A new cluster is started
+// when the timestamp cannot be stored relative to the current cluster.
+func (mkv *matroska) writeBlock(timestamp int64, keyframe bool, data []byte) error {
+	if timestamp < 0 {
+		return fmt.Errorf("vidio: timestamp %d must not be negative", timestamp)
+	}
+
+	buffer := bytes.Buffer{}
+	relative := timestamp - mkv.cluster
+	if mkv.cluster < 0 || relative < -32768 || relative > 32767 {
+		mkv.cluster = timestamp
+		relative = 0
+		buffer.Write(ebmlID(mkvCluster))
+		buffer.Write(mkvUnknownSize)
+		buffer.Write(ebmlUint(mkvTimestamp, uint64(timestamp)))
+	}
+
+	flags := byte(0)
+	if keyframe {
+		flags |= 0x80
+	}
+
+	buffer.Write(ebmlID(mkvSimpleBlock))
+	buffer.Write(ebmlSize(uint64(len(data) + 4)))
+	buffer.WriteByte(0x81) // Track number 1.
+	binary.Write(&buffer, binary.BigEndian, int16(relative))
+	buffer.WriteByte(flags)
+
+	if _, err := mkv.writer.Write(buffer.Bytes()); err != nil {
+		return err
+	}
+	// The data is written separately to avoid copying large frames.
+	_, err := mkv.writer.Write(data)
+	return err
+}
+
+// Encodes an element ID. IDs include their length marker bits.
+func ebmlID(id uint32) []byte {
+	switch {
+	case id > 0xFFFFFF:
+		return []byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	case id > 0xFFFF:
+		return []byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	case id > 0xFF:
+		return []byte{byte(id >> 8), byte(id)}
+	default:
+		return []byte{byte(id)}
+	}
+}
+
+// Encodes an element size as a variable length integer using the fewest bytes.
+func ebmlSize(size uint64) []byte {
+	length := 1
+	// All ones is reserved for unknown sizes.
+	for length < 8 && size >= (uint64(1)<<(7*uint(length)))-1 {
+		length++
+	}
+	encoded := make([]byte, length)
+	for i := length - 1; i >= 0; i-- {
+		encoded[i] = byte(size)
+		size >>= 8
+	}
+	encoded[0] |= 0x80 >> uint(length-1)
+	return encoded
+}
+
+// Encodes an element with the given ID whose data is the concatenation of the given children.
+func ebmlElement(id uint32, children ...[]byte) []byte {
+	data := bytes.Join(children, nil)
+	element := append(ebmlID(id), ebmlSize(uint64(len(data)))...)
+	return append(element, data...)
+}
+
+// Encodes an unsigned integer element using the fewest bytes.
+func ebmlUint(id uint32, value uint64) []byte {
+	data := []byte{byte(value)}
+	for value >>= 8; value > 0; value >>= 8 {
+		data = append([]byte{byte(value)}, data...)
+	}
+	return ebmlElement(id, data)
+}
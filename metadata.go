@@ -0,0 +1,255 @@
+package vidio
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format-level metadata of a video file. Fields are empty if the file does not contain them.
+type Metadata struct {
+	CreationTime time.Time         // Time the video was recorded or created. Zero if unknown.
+	Location     *Location         // Recording location. Nil if unknown.
+	Make         string            // Manufacturer of the recording device.
+	Model        string            // Model of the recording device.
+	Software     string            // Software of the recording device.
+	Encoder      string            // Encoder or application that wrote the file.
+	Tags         map[string]string // All format-level tags.
+}
+
+// Geographic location in decimal degrees and altitude in meters.
+type Location struct {
+	Latitude  float64 // Latitude in degrees. Positive is north.
+	Longitude float64 // Longitude in degrees. Positive is east.
+	Altitude  float64 // Altitude in meters. 0 if unknown.
+}
+
+// Parses ISO 6709 locations, e.g. +37.3349-122.0090+030.000/.
+var iso6709 = regexp.MustCompile(`^([+-][0-9.]+)([+-][0-9.]+)([+-][0-9.]+)?`)
+
+// Returns the format-level metadata of the video file, such as the creation time, location
+// and recording device. The metadata is cached on the video.
+func (video *Video) FormatMetadata() (*Metadata, error) {
+	if video.format != nil {
+		return video.format, nil
+	}
+
+	data, err := probe(video.filename, "-show_format")
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("vidio: no format information found in %s", video.filename)
+	}
+
+	video.format = parseFormatMetadata(data[0])
+	return video.format, nil
+}
+
+// Parses the metadata from the ffprobe format output. QuickTime keys are preferred since they
+// contain the local time zone and the device information of phones and cameras.
+func parseFormatMetadata(data map[string]string) *Metadata {
+	tags := map[string]string{}
+	for key, value := range data {
+		if strings.HasPrefix(key, "tag:") {
+			tags[strings.TrimPrefix(key, "tag:")] = value
+		}
+	}
+
+	first := func(keys ...string) string {
+		for _, key := range keys {
+			if value, ok := tags[key]; ok && value != "" {
+				return value
+			}
+		}
+		return ""
+	}
+
+	metadata := &Metadata{
+		Make:     first("com.apple.quicktime.make", "make"),
+		Model:    first("com.apple.quicktime.model", "model"),
+		Software: first("com.apple.quicktime.software", "software"),
+		Encoder:  first("encoder", "encoded_by"),
+		Tags:     tags,
+	}
+
+	if created, ok := parseCreationTime(first("com.apple.quicktime.creationdate", "creation_time", "date")); ok {
+		metadata.CreationTime = created
+	}
+	metadata.Location = parseISO6709(first("com.apple.quicktime.location.ISO6709", "location", "location-eng"))
+
+	return metadata
+}
+
+// Parses a creation time in one of the layouts used by common containers.
+func parseCreationTime(value string) (time.Time, bool) {
+	layouts := []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05-0700",
+		"2006-01-02T15:04:05",
+		"2006-01-02 15:04:05",
+		"2006-01-02",
+	}
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Parses an ISO 6709 location string. Returns nil if the string is not a valid location.
+func parseISO6709(value string) *Location {
+	match := iso6709.FindStringSubmatch(value)
+	if match == nil {
+		return nil
+	}
+
+	latitude, ok := parseISO6709Angle(match[1], 2)
+	if !ok {
+		return nil
+	}
+	longitude, ok := parseISO6709Angle(match[2], 3)
+	if !ok {
+		return nil
+	}
+
+	location := &Location{Latitude: latitude, Longitude: longitude}
+	if match[3] != "" {
+		location.Altitude, _ = strconv.ParseFloat(match[3], 64)
+	}
+	return location
+}
+
+// Parses a signed ISO 6709 angle in degrees (DD.D), degrees and minutes (DDMM.M) or degrees,
+// minutes and seconds (DDMMSS.S). "digits" is the number of digits of the degrees.
+func parseISO6709Angle(value string, digits int) (float64, bool) {
+	sign := 1.0
+	if value[0] == '-' {
+		sign = -1
+	}
+	value = value[1:]
+
+	integer := strings.IndexByte(value, '.')
+	if integer < 0 {
+		integer = len(value)
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	switch integer {
+	case digits:
+		return sign * number, true
+	case digits + 2:
+		degrees := math.Floor(number / 100)
+		return sign * (degrees + (number-degrees*100)/60), true
+	case digits + 4:
+		degrees := math.Floor(number / 10000)
+		minutes := math.Floor((number - degrees*10000) / 100)
+		return sign * (degrees + minutes/60 + (number-degrees*10000-minutes*100)/3600), true
+	default:
+		return 0, false
+	}
+}
+
+// Sets a format-level metadata tag of the output, e.g. "album".
+func (options *Options) SetMetadata(key, value string) {
+	if options.Metadata == nil {
+		options.Metadata = map[string]string{}
+	}
+	options.Metadata[key] = value
+}
+
+// Sets a metadata tag of the output streams matching the given stream specifier, e.g. "a:0".
+func (options *Options) SetStreamMetadata(stream, key, value string) {
+	if options.StreamMetadata == nil {
+		options.StreamMetadata = map[string]map[string]string{}
+	}
+	if options.StreamMetadata[stream] == nil {
+		options.StreamMetadata[stream] = map[string]string{}
+	}
+	options.StreamMetadata[stream][key] = value
+}
+
+// Sets the title of the output.
+func (options *Options) SetTitle(title string) {
+	options.SetMetadata("title", title)
+}
+
+// Sets the artist of the output.
+func (options *Options) SetArtist(artist string) {
+	options.SetMetadata("artist", artist)
+}
+
+// Sets the comment of the output.
+func (options *Options) SetComment(comment string) {
+	options.SetMetadata("comment", comment)
+}
+
+// Sets the creation time of the output. The time is stored in UTC.
+func (options *Options) SetCreationTime(t time.Time) {
+	options.SetMetadata("creation_time", t.UTC().Format("2006-01-02T15:04:05.000000Z"))
+}
+
+// Sets the language of the output streams matching the given stream specifier, e.g. "a:0".
+// Languages are ISO 639-2 codes such as eng.
+func (options *Options) SetLanguage(stream, language string) {
+	options.SetStreamMetadata(stream, "language", language)
+}
+
+// Sets the clockwise rotation in degrees players apply to the output video when displaying it.
+// The rotation is stored as a display matrix, which requires FFmpeg 6.0 or later. The "rotate"
+// metadata tag used by older versions is ignored by FFmpeg 6.0 and later.
+func (options *Options) SetRotation(degrees int) {
+	options.Rotation = ((degrees % 360) + 360) % 360
+}
+
+// Returns the ffmpeg input arguments tagging the input video with a display matrix rotating
+// it clockwise by the given degrees. -display_rotation is counter-clockwise, and -autorotate 0
+// keeps ffmpeg from rotating the frames themselves when encoding.
+func rotationArgs(degrees int) []string {
+	if degrees == 0 {
+		return nil
+	}
+	return []string{"-display_rotation", fmt.Sprintf("%d", (360-degrees)%360), "-autorotate", "0"}
+}
+
+// Returns the ffmpeg output arguments setting the given format-level and stream metadata.
+// Stream metadata is keyed by stream specifier. Tags are sorted to keep the order stable.
+func metadataArgs(format map[string]string, streams map[string]map[string]string) []string {
+	args := []string{}
+	for _, key := range sortedKeys(format) {
+		args = append(args, "-metadata", key+"="+format[key])
+	}
+
+	specifiers := make([]string, 0, len(streams))
+	for stream := range streams {
+		specifiers = append(specifiers, stream)
+	}
+	sort.Strings(specifiers)
+
+	for _, stream := range specifiers {
+		for _, key := range sortedKeys(streams[stream]) {
+			args = append(args, "-metadata:s:"+stream, key+"="+streams[stream][key])
+		}
+	}
+
+	return args
+}
+
+// Returns the keys of the given map in sorted order.
+func sortedKeys(data map[string]string) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,398 @@
+package vidio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// Packet formats for H.264 and HEVC packets.
+const (
+	AnnexB         = "annexb" // NAL units prefixed with 00 00 00 01 start codes.
+	LengthPrefixed = "length" // NAL units prefixed with their 4 byte big-endian length (AVCC/HVCC).
+)
+
+// An encoded video packet (access unit).
+type Packet struct {
+	Data     []byte  // Encoded packet data.
+	PTS      float64 // Presentation timestamp in seconds.
+	DTS      float64 // Decode timestamp in seconds.
+	Keyframe bool    // True if the packet is a keyframe.
+}
+
+type PacketReader struct {
+	filename      string        // Video filename.
+	stream        int           // Stream index.
+	codec         string        // Codec of the video stream.
+	format        string        // Output packet format for H.264/HEVC.
+	extradata     []byte        // Codec extradata from the container.
+	parametersets [][]byte      // SPS/PPS/VPS NAL units, without start codes or length prefixes.
+	nalsize       int           // Length prefix size of the source packets. 0 if the source is Annex B.
+	packet        *Packet       // Most recently read packet.
+	decoder       *json.Decoder // Decoder over the ffprobe packet list.
+	pipe          io.ReadCloser // Stdout pipe for ffprobe process.
+	cmd           *exec.Cmd     // ffprobe command dumping the packets.
+}
+
+func (reader *PacketReader) FileName() string {
+	return reader.filename
+}
+
+// Returns the zero-indexed video stream index.
+func (reader *PacketReader) Stream() int {
+	return reader.stream
+}
+
+func (reader *PacketReader) Codec() string {
+	return reader.codec
+}
+
+// Packet format of H.264 and HEVC packets. Either AnnexB or LengthPrefixed.
+func (reader *PacketReader) Format() string {
+	return reader.format
+}
+
+// Raw codec extradata as stored in the container, e.g. an avcC or hvcC record.
+func (reader *PacketReader) Extradata() []byte {
+	return reader.extradata
+}
+
+// H.264/HEVC parameter sets (VPS, SPS and PPS) from the extradata, without start codes or length prefixes.
+func (reader *PacketReader) ParameterSets() [][]byte {
+	return reader.parametersets
+}
+
+// Most recently read packet.
+func (reader *PacketReader) Packet() *Packet {
+	return reader.packet
+}
+
+// Creates a new PacketReader that reads the encoded packets of the given video stream
+// without decoding. H.264 and HEVC packets are returned in the given format (AnnexB or
+// LengthPrefixed). Packets of other codecs are returned as stored in the container.
+func NewPacketReader(video *Video, format string) (*PacketReader, error) {
+	if format != AnnexB && format != LengthPrefixed {
+		return nil, fmt.Errorf("vidio: unsupported packet format %s", format)
+	}
+
+	reader := &PacketReader{
+		filename: video.filename,
+		stream:   video.stream,
+		codec:    video.codec,
+		format:   format,
+	}
+
+	probe := struct {
+		Streams []struct {
+			Extradata string `json:"extradata"`
+		} `json:"streams"`
+	}{}
+
+	if err := ffprobeJSON(
+		reader.filename,
+		&probe,
+		"-select_streams", fmt.Sprintf("v:%d", reader.stream),
+		"-show_streams",
+		"-show_data",
+	); err != nil {
+		return nil, err
+	}
+
+	if len(probe.Streams) > 0 {
+		extradata, err := parseHexDump(probe.Streams[0].Extradata)
+		if err != nil {
+			return nil, fmt.Errorf("vidio: failed to parse extradata: %w", err)
+		}
+		reader.extradata = extradata
+		reader.parametersets, reader.nalsize = parseExtradata(reader.codec, extradata)
+	}
+
+	return reader, nil
+}
+
+// Once the user calls Read() for the first time on a PacketReader struct, the ffprobe
+// command dumping the packets of the video stream is started. Each packet is printed
+// with its timestamps, flags and data, so the packet boundaries come from the demuxer.
+func (reader *PacketReader) init() error {
+	// If user exits with Ctrl+C, stop ffprobe process.
+	reader.cleanup()
+
+	cmd := exec.Command(
+		"ffprobe",
+		"-select_streams", fmt.Sprintf("v:%d", reader.stream),
+		"-show_entries", "packet=pts_time,dts_time,size,flags,data",
+		"-show_data",
+		"-print_format", "json",
+		"-loglevel", "quiet",
+		reader.filename,
+	)
+
+	pipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	reader.cmd = cmd
+	reader.pipe = pipe
+	reader.decoder = json.NewDecoder(bufio.NewReader(pipe))
+
+	// Skip to the start of the "packets" array.
+	for {
+		token, err := reader.decoder.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := token.(json.Delim); ok && delim == '[' {
+			return nil
+		}
+	}
+}
+
+// Reads the next packet from the video. If the last packet has been read, returns false, otherwise true.
+func (reader *PacketReader) Read() bool {
+	// If cmd is nil, packet reading has not been initialized.
+	if reader.cmd == nil {
+		if err := reader.init(); err != nil {
+			reader.Close()
+			return false
+		}
+	}
+
+	for reader.decoder.More() {
+		packet := map[string]interface{}{}
+		if err := reader.decoder.Decode(&packet); err != nil {
+			break
+		}
+
+		data := map[string]string{}
+		for key, value := range packet {
+			data[key] = fmt.Sprint(value)
+		}
+		// Packets the demuxer marks as discarded are not part of the stream.
+		if strings.Contains(data["flags"], "D") {
+			continue
+		}
+
+		buffer, err := parseHexDump(data["data"])
+		// A packet whose data does not match its size means the dump is corrupt.
+		if err != nil || len(buffer) != int(parse(data["size"])) {
+			break
+		}
+
+		info := parsePacketInfo(data)
+		reader.packet = &Packet{
+			Data:     reader.convert(buffer, info.keyframe),
+			PTS:      info.pts,
+			DTS:      info.dts,
+			Keyframe: info.keyframe,
+		}
+		return true
+	}
+
+	reader.Close()
+	return false
+}
+
+// Converts the packet data to the requested format.
+func (reader *PacketReader) convert(data []byte, keyframe bool) []byte {
+	if reader.codec != "h264" && reader.codec != "hevc" {
+		return data
+	}
+
+	switch {
+	case reader.format == AnnexB && reader.nalsize > 0:
+		nals := splitLengthPrefixed(data, reader.nalsize)
+		// Keyframes carry the parameter sets in-band so the stream can be decoded from any keyframe.
+		if keyframe {
+			nals = append(append([][]byte{}, reader.parametersets...), nals...)
+		}
+		return joinAnnexB(nals)
+	case reader.format == LengthPrefixed && reader.nalsize == 0:
+		return joinLengthPrefixed(splitAnnexB(data))
+	case reader.format == LengthPrefixed && reader.nalsize != 4:
+		return joinLengthPrefixed(splitLengthPrefixed(data, reader.nalsize))
+	default:
+		return data
+	}
+}
+
+// Closes the pipe and stops the ffprobe process.
+func (reader *PacketReader) Close() {
+	if reader.pipe != nil {
+		reader.pipe.Close()
+	}
+	if reader.cmd != nil && reader.cmd.Process != nil {
+		reader.cmd.Process.Kill()
+		reader.cmd.Wait()
+	}
+}
+
+// Stops the "cmd" process running when the user presses Ctrl+C.
+// https://stackoverflow.com/questions/11268943/is-it-possible-to-capture-a-ctrlc-signal-and-run-a-cleanup-function-in-a-defe.
+func (reader *PacketReader) cleanup() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		if reader.pipe != nil {
+			reader.pipe.Close()
+		}
+		if reader.cmd != nil && reader.cmd.Process != nil {
+			reader.cmd.Process.Kill()
+		}
+		os.Exit(1)
+	}()
+}
+
+// Parses the hex dump printed by ffprobe -show_data. Each line has the form
+// "00000000: 0164 001f ffe1 0018 6764 001f acd9 4078  .d......gd....@x".
+func parseHexDump(dump string) ([]byte, error) {
+	data := []byte{}
+	for _, line := range strings.Split(dump, "\n") {
+		index := strings.Index(line, ": ")
+		if index == -1 {
+			continue
+		}
+		// The hex digits take up the first 40 characters, followed by the ASCII representation.
+		line = line[index+2:]
+		if len(line) > 40 {
+			line = line[:40]
+		}
+		decoded, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(line), " ", ""))
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, decoded...)
+	}
+	return data, nil
+}
+
+// Extracts the parameter sets and NAL unit length prefix size from H.264 (avcC) or HEVC (hvcC)
+// extradata. Annex B extradata is split on start codes and has a length prefix size of 0.
+func parseExtradata(codec string, extradata []byte) ([][]byte, int) {
+	if len(extradata) == 0 {
+		return nil, 0
+	}
+	if extradata[0] != 1 {
+		return splitAnnexB(extradata), 0
+	}
+
+	// Reads "count" length-prefixed parameter sets starting at "offset".
+	read := func(offset, count int) ([][]byte, int) {
+		sets := [][]byte{}
+		for i := 0; i < count && offset+2 <= len(extradata); i++ {
+			size := int(binary.BigEndian.Uint16(extradata[offset:]))
+			offset += 2
+			if offset+size > len(extradata) {
+				break
+			}
+			sets = append(sets, extradata[offset:offset+size])
+			offset += size
+		}
+		return sets, offset
+	}
+
+	switch codec {
+	case "h264":
+		// https://www.iso.org/standard/55980.html (ISO/IEC 14496-15, AVCDecoderConfigurationRecord).
+		if len(extradata) < 7 {
+			return nil, 0
+		}
+		nalsize := int(extradata[4]&3) + 1
+		sps, offset := read(6, int(extradata[5]&0x1f))
+		if offset >= len(extradata) {
+			return sps, nalsize
+		}
+		pps, _ := read(offset+1, int(extradata[offset]))
+		return append(sps, pps...), nalsize
+	case "hevc":
+		// ISO/IEC 14496-15, HEVCDecoderConfigurationRecord.
+		if len(extradata) < 23 {
+			return nil, 0
+		}
+		nalsize := int(extradata[21]&3) + 1
+		sets := [][]byte{}
+		offset := 23
+		for i := 0; i < int(extradata[22]) && offset+3 <= len(extradata); i++ {
+			count := int(binary.BigEndian.Uint16(extradata[offset+1:]))
+			var array [][]byte
+			array, offset = read(offset+3, count)
+			sets = append(sets, array...)
+		}
+		return sets, nalsize
+	default:
+		return nil, 0
+	}
+}
+
+// Splits Annex B data on 3 or 4 byte start codes into NAL units.
+func splitAnnexB(data []byte) [][]byte {
+	nals := [][]byte{}
+	start := -1
+	for i := 0; i+2 < len(data); i++ {
+		if data[i] == 0 && data[i+1] == 0 && data[i+2] == 1 {
+			if start >= 0 {
+				nals = append(nals, bytes.TrimRight(data[start:i], "\x00"))
+			}
+			start = i + 3
+			i += 2
+		}
+	}
+	if start >= 0 && start <= len(data) {
+		nals = append(nals, data[start:])
+	}
+	return nals
+}
+
+// Splits length-prefixed data into NAL units.
+func splitLengthPrefixed(data []byte, nalsize int) [][]byte {
+	nals := [][]byte{}
+	for offset := 0; offset+nalsize <= len(data); {
+		size := 0
+		for _, b := range data[offset : offset+nalsize] {
+			size = size<<8 | int(b)
+		}
+		offset += nalsize
+		if offset+size > len(data) {
+			break
+		}
+		nals = append(nals, data[offset:offset+size])
+		offset += size
+	}
+	return nals
+}
+
+// Joins NAL units with 4 byte start codes.
+func joinAnnexB(nals [][]byte) []byte {
+	buffer := bytes.Buffer{}
+	for _, nal := range nals {
+		buffer.Write([]byte{0, 0, 0, 1})
+		buffer.Write(nal)
+	}
+	return buffer.Bytes()
+}
+
+// Joins NAL units with 4 byte length prefixes.
+func joinLengthPrefixed(nals [][]byte) []byte {
+	buffer := bytes.Buffer{}
+	prefix := make([]byte, 4)
+	for _, nal := range nals {
+		binary.BigEndian.PutUint32(prefix, uint32(len(nal)))
+		buffer.Write(prefix)
+		buffer.Write(nal)
+	}
+	return buffer.Bytes()
+}
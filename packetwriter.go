@@ -0,0 +1,217 @@
+package vidio
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+type PacketWriter struct {
+	filename   string         // Output filename.
+	streamfile string         // Extra stream data filename.
+	streams    []StreamFile   // Files with extra streams, including the stream data file.
+	length     string         // Policy for the output length with extra streams.
+	width      int            // Frame width.
+	height     int            // Frame height.
+	codec      string         // Codec of the encoded packets. Default h264.
+	extradata  []byte         // Codec extradata.
+	mkv        *matroska      // Matroska stream passing the packets to ffmpeg.
+	pipe       io.WriteCloser // Stdin pipe of ffmpeg process.
+	cmd        *exec.Cmd      // ffmpeg command.
+}
+
+func (writer *PacketWriter) FileName() string {
+	return writer.filename
+}
+
+// File used to fill in extra stream data.
+func (writer *PacketWriter) StreamFile() string {
+	return writer.streamfile
+}
+
+// Files used to fill in extra streams, including the stream data file.
+func (writer *PacketWriter) StreamFiles() []StreamFile {
+	return writer.streams
+}
+
+// Policy determining the output length with extra streams.
+func (writer *PacketWriter) Length() string {
+	return writer.length
+}
+
+func (writer *PacketWriter) Width() int {
+	return writer.width
+}
+
+func (writer *PacketWriter) Height() int {
+	return writer.height
+}
+
+// Codec of the encoded packets.
+func (writer *PacketWriter) Codec() string {
+	return writer.codec
+}
+
+// Codec extradata of the encoded packets.
+func (writer *PacketWriter) Extradata() []byte {
+	return writer.extradata
+}
+
+// Creates a new PacketWriter which writes pre-encoded packets to the given file without re-encoding.
+// "extradata" is the codec extradata, e.g. SPS/PPS in Annex B or avcC format, or nil if the packets
+// carry their parameter sets. Options.Codec is the codec of the packets (default h264) and
+// Options.StreamFile, Options.StreamFiles and Options.Length work the same as for the VideoWriter,
+// except that LengthAudio is not supported since the video is not re-encoded. All other options are ignored.
+func NewPacketWriter(filename string, width, height int, extradata []byte, options *Options) (*PacketWriter, error) {
+	// Check if ffmpeg is installed on the users machine.
+	if err := installed("ffmpeg"); err != nil {
+		return nil, err
+	}
+
+	if options == nil {
+		options = &Options{}
+	}
+
+	writer := &PacketWriter{
+		filename:  filename,
+		width:     width,
+		height:    height,
+		codec:     options.Codec,
+		extradata: extradata,
+	}
+
+	if writer.codec == "" {
+		writer.codec = "h264"
+	}
+	if _, ok := mkvCodecs[writer.codec]; !ok {
+		return nil, fmt.Errorf("vidio: codec %s is not supported by the PacketWriter", writer.codec)
+	}
+
+	streams, err := streamFiles(options)
+	if err != nil {
+		return nil, err
+	}
+	writer.streamfile = options.StreamFile
+	writer.streams = streams
+
+	length, err := lengthPolicy(options.Length, streams)
+	if err != nil {
+		return nil, err
+	}
+	if length == LengthAudio {
+		return nil, fmt.Errorf("vidio: the PacketWriter does not support the length policy %s", length)
+	}
+	writer.length = length
+
+	return writer, nil
+}
+
+// Once the user calls Write() for the first time on a PacketWriter struct,
+// the ffmpeg command which is used to write to the video file is started.
+func (writer *PacketWriter) init() error {
+	// If user exits with Ctrl+C, stop ffmpeg process.
+	writer.cleanup()
+	// ffmpeg command to copy the packets into the output container.
+	// The packets are passed through stdin in a Matroska stream to preserve their timestamps.
+	command := []string{
+		"-y", // overwrite output file if it exists.
+		"-loglevel", "quiet",
+		"-f", "matroska",
+		"-i", "-", // The input comes from stdin.
+	}
+
+	if len(writer.streams) > 0 && !strings.HasSuffix(strings.ToLower(writer.filename), ".gif") {
+		// Padding the audio requires re-encoding it.
+		acodec := ""
+		if writer.length == LengthVideo {
+			acodec = audioEncoder(writer.filename)
+		}
+
+		inputs, args, _, err := streamFileArgs(writer.streams, 1, 1, acodec)
+		if err != nil {
+			return err
+		}
+		command = append(command, inputs...)
+		command = append(command, "-map", "0:v:0")
+		command = append(command, args...)
+		command = append(command, lengthArgs(writer.length)...)
+	}
+
+	command = append(command, "-c:v", "copy", writer.filename)
+	cmd := exec.Command("ffmpeg", command...)
+
+	pipe, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	if err := cmd.Start(); err != nil {
+		pipe.Close()
+		return err
+	}
+
+	mkv, err := newMatroska(pipe, writer.codec, writer.width, writer.height, writer.extradata, nil)
+	if err != nil {
+		pipe.Close()
+		cmd.Process.Kill()
+		cmd.Wait()
+		return err
+	}
+
+	// Only set up the writer once ffmpeg is running, so a failed start is retried by the next Write().
+	writer.cmd = cmd
+	writer.pipe = pipe
+	writer.mkv = mkv
+
+	return nil
+}
+
+// Writes the given packet to the video file. Packets must be written in decode order
+// with non-negative presentation timestamps. Decode timestamps are derived by ffmpeg.
+// H.264/HEVC packets may be in Annex B or length-prefixed format, matching the extradata.
+func (writer *PacketWriter) Write(packet *Packet) error {
+	// If mkv is nil, video writing has not been set up.
+	if writer.mkv == nil {
+		if err := writer.init(); err != nil {
+			return err
+		}
+	}
+
+	timestamp := int64(math.Round(packet.PTS * 1e6))
+	return writer.mkv.writeBlock(timestamp, packet.Keyframe, packet.Data)
+}
+
+// Closes the pipe and waits for the ffmpeg process to finish writing the file.
+// Returns the first error of closing the pipe or of ffmpeg.
+func (writer *PacketWriter) Close() error {
+	errs := []error{}
+	if writer.pipe != nil {
+		errs = append(errs, writer.pipe.Close())
+	}
+	if writer.cmd != nil {
+		errs = append(errs, writer.cmd.Wait())
+	}
+	return firstError(errs)
+}
+
+// Stops the "cmd" process running when the user presses Ctrl+C.
+// https://stackoverflow.com/questions/11268943/is-it-possible-to-capture-a-ctrlc-signal-and-run-a-cleanup-function-in-a-defe.
+func (writer *PacketWriter) cleanup() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-c
+		if writer.pipe != nil {
+			writer.pipe.Close()
+		}
+		if writer.cmd != nil {
+			writer.cmd.Process.Kill()
+		}
+		os.Exit(1)
+	}()
+}
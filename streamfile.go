@@ -0,0 +1,136 @@
+package vidio
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policies for the length of an output with audio or streams from other files.
+const (
+	LengthShortest = "shortest" // The output ends with its shortest stream.
+	LengthLongest  = "longest"  // The output ends with its longest stream.
+	LengthVideo    = "video"    // Audio is cut or padded with silence to the length of the video. Audio is re-encoded.
+	LengthAudio    = "audio"    // Video is cut or its last frame repeated to the length of the audio.
+)
+
+// A file whose streams are added to the output of a VideoWriter or PacketWriter.
+type StreamFile struct {
+	Filename string            // File path.
+	Offset   float64           // Start position in the file in seconds.
+	Duration float64           // Duration to use from the file in seconds. 0 uses the rest of the file.
+	Streams  []StreamSelection // Streams to add. Default all audio, subtitle, data and attachment streams.
+}
+
+// Streams added from a StreamFile if no streams are selected.
+var defaultStreams = []StreamSelection{
+	{Type: "a", Index: -1},
+	{Type: "s", Index: -1},
+	{Type: "d", Index: -1},
+	{Type: "t", Index: -1},
+}
+
+// Returns the stream files given by Options.StreamFile and Options.StreamFiles, in that order.
+func streamFiles(options *Options) ([]StreamFile, error) {
+	files := []StreamFile{}
+	if options.StreamFile != "" {
+		files = append(files, StreamFile{Filename: options.StreamFile})
+	}
+	files = append(files, options.StreamFiles...)
+
+	for _, file := range files {
+		if !exists(file.Filename) {
+			return nil, fmt.Errorf("vidio: file %s does not exist", file.Filename)
+		}
+		if file.Offset < 0 || file.Duration < 0 {
+			return nil, fmt.Errorf("vidio: offset and duration of %s must not be negative", file.Filename)
+		}
+	}
+
+	return files, nil
+}
+
+// Returns the length policy given by Options.Length. Defaults to LengthShortest if streams
+// from other files are added, otherwise to LengthLongest.
+func lengthPolicy(length string, files []StreamFile) (string, error) {
+	switch length {
+	case "":
+		if len(files) > 0 {
+			return LengthShortest, nil
+		}
+		return LengthLongest, nil
+	case LengthShortest, LengthLongest, LengthVideo, LengthAudio:
+		return length, nil
+	default:
+		return "", fmt.Errorf("vidio: unsupported length policy %s", length)
+	}
+}
+
+// Returns the default audio encoder for the container of the given file.
+func audioEncoder(filename string) string {
+	filename = strings.ToLower(filename)
+	switch {
+	case strings.HasSuffix(filename, ".webm"):
+		return "libopus"
+	case strings.HasSuffix(filename, ".wmv"):
+		return "wmav2"
+	default:
+		return "aac"
+	}
+}
+
+// Returns the ffmpeg input arguments of the given stream files, the output arguments mapping
+// their selected streams and the index of the next output. The files are inputs starting at index
+// "input" and their streams are mapped to the outputs starting at index "output". Audio is encoded
+// with "acodec" if given.
+func streamFileArgs(files []StreamFile, input, output int, acodec string) ([]string, []string, int, error) {
+	inputs := []string{}
+	maps := []string{}
+	for i, file := range files {
+		streams, err := probeStreams(file.Filename)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		selection := file.Streams
+		if len(selection) == 0 {
+			selection = defaultStreams
+		}
+
+		args, next, err := selectionArgs(streams, selection, input+i, output, acodec)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		output = next
+
+		inputs = append(inputs, streamFileInput(file)...)
+		maps = append(maps, args...)
+	}
+
+	return inputs, maps, output, nil
+}
+
+// Returns the ffmpeg input arguments of the given stream file.
+func streamFileInput(file StreamFile) []string {
+	args := []string{}
+	if file.Offset > 0 {
+		args = append(args, "-ss", seconds(file.Offset))
+	}
+	if file.Duration > 0 {
+		args = append(args, "-t", seconds(file.Duration))
+	}
+	return append(args, "-i", file.Filename)
+}
+
+// Returns the ffmpeg output arguments applying the given length policy. LengthAudio
+// additionally requires the last video frame to be repeated with the tpad filter.
+func lengthArgs(length string) []string {
+	switch length {
+	case LengthShortest, LengthAudio:
+		return []string{"-shortest"}
+	case LengthVideo:
+		// The audio is padded with silence indefinitely, so the video is the shortest stream.
+		return []string{"-filter:a", "apad", "-shortest"}
+	default:
+		return nil
+	}
+}
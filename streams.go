@@ -0,0 +1,133 @@
+package vidio
+
+// Disposition flags of a stream.
+type Disposition struct {
+	Default         bool // Default stream of its type.
+	Dub             bool // Dubbed audio.
+	Original        bool // Original language.
+	Comment         bool // Commentary.
+	Lyrics          bool // Lyrics.
+	Karaoke         bool // Karaoke.
+	Forced          bool // Forced subtitles.
+	HearingImpaired bool // For the hearing impaired.
+	VisualImpaired  bool // For the visually impaired.
+	CleanEffects    bool // Audio without voice.
+	AttachedPic     bool // Attached picture, e.g. cover art.
+	Captions        bool // Closed captions.
+	Descriptions    bool // Audio descriptions.
+}
+
+// An audio stream of a video file.
+type AudioStream struct {
+	Index       int         // Index of the stream within the file.
+	Stream      int         // Zero-indexed audio stream index.
+	Codec       string      // Codec, e.g. aac or opus.
+	Channels    int         // Number of channels.
+	Layout      string      // Channel layout, e.g. stereo or 5.1.
+	SampleRate  int         // Sample rate in Hz.
+	Bitrate     int         // Bitrate in bits/s.
+	Language    string      // Language tag, e.g. eng.
+	Title       string      // Title tag.
+	Duration    float64     // Duration in seconds.
+	Disposition Disposition // Disposition flags.
+}
+
+// A subtitle stream of a video file.
+type SubtitleStream struct {
+	Index       int         // Index of the stream within the file.
+	Stream      int         // Zero-indexed subtitle stream index.
+	Codec       string      // Codec, e.g. subrip, ass, webvtt or mov_text.
+	Language    string      // Language tag, e.g. eng.
+	Title       string      // Title tag.
+	Duration    float64     // Duration in seconds.
+	Disposition Disposition // Disposition flags.
+}
+
+// An attachment stream of a video file, e.g. a font in a Matroska file.
+type AttachmentStream struct {
+	Index       int         // Index of the stream within the file.
+	Stream      int         // Zero-indexed attachment stream index.
+	Codec       string      // Codec, e.g. ttf.
+	Filename    string      // Filename of the attachment.
+	MimeType    string      // MIME type of the attachment.
+	Disposition Disposition // Disposition flags.
+}
+
+// Audio streams of the video file.
+func (video *Video) AudioStreams() []AudioStream {
+	return video.audio
+}
+
+// Subtitle streams of the video file.
+func (video *Video) SubtitleStreams() []SubtitleStream {
+	return video.subtitles
+}
+
+// Attachment streams of the video file.
+func (video *Video) AttachmentStreams() []AttachmentStream {
+	return video.attachments
+}
+
+// Parses the disposition flags from the ffprobe stream output.
+func parseDisposition(data map[string]string) Disposition {
+	flag := func(name string) bool {
+		return data["disposition:"+name] == "1"
+	}
+	return Disposition{
+		Default:         flag("default"),
+		Dub:             flag("dub"),
+		Original:        flag("original"),
+		Comment:         flag("comment"),
+		Lyrics:          flag("lyrics"),
+		Karaoke:         flag("karaoke"),
+		Forced:          flag("forced"),
+		HearingImpaired: flag("hearing_impaired"),
+		VisualImpaired:  flag("visual_impaired"),
+		CleanEffects:    flag("clean_effects"),
+		AttachedPic:     flag("attached_pic"),
+		Captions:        flag("captions"),
+		Descriptions:    flag("descriptions"),
+	}
+}
+
+// Parses an audio stream from the ffprobe stream output.
+func parseAudioStream(stream int, data map[string]string) AudioStream {
+	return AudioStream{
+		Index:       int(parse(data["index"])),
+		Stream:      stream,
+		Codec:       data["codec_name"],
+		Channels:    int(parse(data["channels"])),
+		Layout:      data["channel_layout"],
+		SampleRate:  int(parse(data["sample_rate"])),
+		Bitrate:     int(parse(data["bit_rate"])),
+		Language:    data["tag:language"],
+		Title:       data["tag:title"],
+		Duration:    parse(data["duration"]),
+		Disposition: parseDisposition(data),
+	}
+}
+
+// Parses a subtitle stream from the ffprobe stream output.
+func parseSubtitleStream(stream int, data map[string]string) SubtitleStream {
+	return SubtitleStream{
+		Index:       int(parse(data["index"])),
+		Stream:      stream,
+		Codec:       data["codec_name"],
+		Language:    data["tag:language"],
+		Title:       data["tag:title"],
+		Duration:    parse(data["duration"]),
+		Disposition: parseDisposition(data),
+	}
+}
+
+// Parses an attachment stream from the ffprobe stream output.
+func parseAttachmentStream(stream int, data map[string]string) AttachmentStream {
+	return AttachmentStream{
+		Index:       int(parse(data["index"])),
+		Stream:      stream,
+		Codec:       data["codec_name"],
+		Filename:    data["tag:filename"],
+		MimeType:    data["tag:mimetype"],
+		Disposition: parseDisposition(data),
+	}
+}
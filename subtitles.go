@@ -0,0 +1,198 @@
+package vidio
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// A single subtitle cue.
+type Cue struct {
+	Index int     // One-indexed position of the cue in the track.
+	Start float64 // Start time in seconds.
+	End   float64 // End time in seconds.
+	Text  string  // Text of the cue. Lines are separated by "\n" and may contain tags such as <i>.
+}
+
+// Subtitle codecs rendered as images, which cannot be converted to text.
+var bitmapSubtitles = map[string]bool{
+	"hdmv_pgs_subtitle": true,
+	"dvd_subtitle":      true,
+	"dvb_subtitle":      true,
+	"xsub":              true,
+}
+
+// Subtitle encoders used for each file extension by ExportSubtitles.
+var subtitleEncoders = map[string]string{
+	".srt": "srt",
+	".ass": "ass",
+	".ssa": "ass",
+	".vtt": "webvtt",
+}
+
+// Subtitle codecs used for soft subtitles in each container, by the extension of the source file.
+var containerSubtitles = map[string]map[string]string{
+	".mp4":  {"": "mov_text"},
+	".m4v":  {"": "mov_text"},
+	".mov":  {"": "mov_text"},
+	".mkv":  {".ass": "ass", ".ssa": "ass", ".vtt": "webvtt", "": "srt"},
+	".webm": {"": "webvtt"},
+}
+
+// Returns the subtitle codec used to mux subtitles from a file with the "source" extension
+// into the given output file. Styling of ASS subtitles is only kept in Matroska.
+func subtitleCodec(filename, source string) (string, error) {
+	codecs, ok := containerSubtitles[strings.ToLower(filepath.Ext(filename))]
+	if !ok {
+		return "", fmt.Errorf("vidio: %s does not support subtitle streams, use BurnSubtitles instead", filepath.Ext(filename))
+	}
+	if codec, ok := codecs[source]; ok {
+		return codec, nil
+	}
+	return codecs[""], nil
+}
+
+// Returns the cues of the subtitle stream with the given zero-indexed subtitle stream index.
+// Only text subtitles (e.g. SRT, ASS, WebVTT and mov_text) are supported.
+func (video *Video) Subtitles(stream int) ([]Cue, error) {
+	if err := video.textSubtitles(stream); err != nil {
+		return nil, err
+	}
+
+	output, err := ffmpegOutput(
+		"-i", video.filename,
+		"-map", fmt.Sprintf("0:s:%d", stream),
+		"-c:s", "srt",
+		"-f", "srt",
+		"-",
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSRT(string(output))
+}
+
+// Writes the subtitle stream with the given zero-indexed subtitle stream index to the given file.
+// The format is chosen by the file extension: .srt, .ass, .ssa or .vtt.
+func (video *Video) ExportSubtitles(stream int, filename string) error {
+	encoder, ok := subtitleEncoders[strings.ToLower(filepath.Ext(filename))]
+	if !ok {
+		return fmt.Errorf("vidio: unsupported subtitle format %s", filepath.Ext(filename))
+	}
+
+	if err := video.textSubtitles(stream); err != nil {
+		return err
+	}
+
+	return ffmpeg(
+		"-i", video.filename,
+		"-map", fmt.Sprintf("0:s:%d", stream),
+		"-c:s", encoder,
+		filename,
+	)
+}
+
+// Checks that the video file has a text subtitle stream with the given index.
+func (video *Video) textSubtitles(stream int) error {
+	streams, err := ffprobe(video.filename, "s")
+	if err != nil {
+		return err
+	}
+	if stream < 0 || stream >= len(streams) {
+		return fmt.Errorf("vidio: no subtitle stream with index %d found in %s", stream, video.filename)
+	}
+	if codec := streams[stream]["codec_name"]; bitmapSubtitles[codec] {
+		return fmt.Errorf("vidio: subtitle stream %d uses the bitmap codec %s and cannot be converted to text", stream, codec)
+	}
+	return nil
+}
+
+// Parses subtitles in SRT format.
+func parseSRT(data string) ([]Cue, error) {
+	data = strings.TrimPrefix(data, "\ufeff")
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+
+	cues := []Cue{}
+	for _, block := range strings.Split(data, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		if len(lines) == 0 || strings.TrimSpace(lines[0]) == "" {
+			continue
+		}
+
+		// The index line is optional in practice.
+		index := len(cues) + 1
+		if !strings.Contains(lines[0], "-->") {
+			if n, err := strconv.Atoi(strings.TrimSpace(lines[0])); err == nil {
+				index = n
+			}
+			lines = lines[1:]
+		}
+		if len(lines) == 0 {
+			continue
+		}
+
+		// The timing line may be followed by position information.
+		times := strings.Fields(lines[0])
+		if len(times) < 3 || times[1] != "-->" {
+			return nil, fmt.Errorf("vidio: invalid SRT timing line %q", lines[0])
+		}
+		start, err := parseSRTTime(times[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseSRTTime(times[2])
+		if err != nil {
+			return nil, err
+		}
+
+		cues = append(cues, Cue{
+			Index: index,
+			Start: start,
+			End:   end,
+			Text:  strings.Join(lines[1:], "\n"),
+		})
+	}
+
+	return cues, nil
+}
+
+// Parses an SRT timestamp of the form HH:MM:SS,mmm into seconds.
+func parseSRTTime(timestamp string) (float64, error) {
+	parts := strings.Split(strings.Replace(timestamp, ",", ".", 1), ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("vidio: invalid SRT timestamp %q", timestamp)
+	}
+
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("vidio: invalid SRT timestamp %q", timestamp)
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("vidio: invalid SRT timestamp %q", timestamp)
+	}
+	secs, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0, fmt.Errorf("vidio: invalid SRT timestamp %q", timestamp)
+	}
+
+	return float64(hours*3600+minutes*60) + secs, nil
+}
+
+// Formats the given cues as SRT. Cues are numbered in the given order.
+func formatSRT(cues []Cue) string {
+	builder := strings.Builder{}
+	for i, cue := range cues {
+		fmt.Fprintf(&builder, "%d\n%s --> %s\n%s\n\n", i+1, formatSRTTime(cue.Start), formatSRTTime(cue.End), cue.Text)
+	}
+	return builder.String()
+}
+
+// Formats the given time in seconds as an SRT timestamp of the form HH:MM:SS,mmm.
+func formatSRTTime(t float64) string {
+	ms := int64(math.Round(math.Max(t, 0) * 1000))
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
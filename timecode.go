@@ -0,0 +1,184 @@
+package vidio
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// An SMPTE timecode. Drop-frame timecodes skip frame numbers to stay in sync with the
+// wall clock at 29.97 and 59.94 fps.
+type Timecode struct {
+	Hours     int  // Hours, 0 to 23.
+	Minutes   int  // Minutes, 0 to 59.
+	Seconds   int  // Seconds, 0 to 59.
+	Frames    int  // Frames within the second.
+	DropFrame bool // True for drop-frame timecode.
+}
+
+// Parses a timecode of the form HH:MM:SS:FF. A semicolon or comma before the frames
+// (HH:MM:SS;FF) marks a drop-frame timecode.
+func ParseTimecode(timecode string) (Timecode, error) {
+	timecode = strings.TrimSpace(timecode)
+	// The separator before the frames marks drop-frame timecodes.
+	split := strings.LastIndexAny(timecode, ":;,.")
+	if split < 0 {
+		return Timecode{}, fmt.Errorf("vidio: invalid timecode %q", timecode)
+	}
+
+	parts := strings.Split(timecode[:split], ":")
+	parts = append(parts, timecode[split+1:])
+	if len(parts) != 4 {
+		return Timecode{}, fmt.Errorf("vidio: invalid timecode %q", timecode)
+	}
+
+	values := make([]int, 4)
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 {
+			return Timecode{}, fmt.Errorf("vidio: invalid timecode %q", timecode)
+		}
+		values[i] = value
+	}
+	if values[1] > 59 || values[2] > 59 {
+		return Timecode{}, fmt.Errorf("vidio: invalid timecode %q", timecode)
+	}
+
+	result := Timecode{
+		Hours:     values[0],
+		Minutes:   values[1],
+		Seconds:   values[2],
+		Frames:    values[3],
+		DropFrame: timecode[split] != ':',
+	}
+	// Frame numbers 00 and 01 are skipped at the start of every minute except every tenth minute
+	// at all drop-frame rates.
+	if result.dropped(2) {
+		return Timecode{}, fmt.Errorf("vidio: drop-frame timecode %q does not exist", timecode)
+	}
+
+	return result, nil
+}
+
+// Checks that the timecode exists at the given frame rate: the frames must be less than the
+// nominal frame rate, drop-frame timecodes require 29.97 or 59.94 fps and must not use the
+// skipped frame numbers.
+func (timecode Timecode) Valid(fps float64) error {
+	nominal, drop := timecodeRate(fps, timecode.DropFrame)
+	if timecode.DropFrame && drop == 0 {
+		return fmt.Errorf("vidio: drop-frame timecode %s requires 29.97 or 59.94 fps, got %g", timecode, fps)
+	}
+	if timecode.Frames >= nominal {
+		return fmt.Errorf("vidio: timecode %s has more frames than %d fps", timecode, nominal)
+	}
+	if timecode.dropped(drop) {
+		return fmt.Errorf("vidio: drop-frame timecode %s does not exist", timecode)
+	}
+	return nil
+}
+
+// Returns true if the timecode is one of the given number of frame numbers skipped in
+// drop-frame counting.
+func (timecode Timecode) dropped(drop int) bool {
+	return timecode.DropFrame && timecode.Seconds == 0 && timecode.Minutes%10 != 0 && timecode.Frames < drop
+}
+
+// Formats the timecode as HH:MM:SS:FF, or HH:MM:SS;FF for drop-frame timecode.
+func (timecode Timecode) String() string {
+	separator := ":"
+	if timecode.DropFrame {
+		separator = ";"
+	}
+	return fmt.Sprintf(
+		"%02d:%02d:%02d%s%02d",
+		timecode.Hours, timecode.Minutes, timecode.Seconds, separator, timecode.Frames,
+	)
+}
+
+// Returns the number of frames since 00:00:00:00 at the given frame rate.
+// Drop-frame counting is only defined for 29.97 and 59.94 fps and ignored otherwise.
+// The result is only meaningful for timecodes that are Valid() at the frame rate.
+func (timecode Timecode) Frame(fps float64) int {
+	nominal, drop := timecodeRate(fps, timecode.DropFrame)
+
+	minutes := timecode.Hours*60 + timecode.Minutes
+	frame := (minutes*60+timecode.Seconds)*nominal + timecode.Frames
+	// Two (or four) frame numbers are skipped every minute, except every tenth minute.
+	return frame - drop*(minutes-minutes/10)
+}
+
+// Returns the timecode the given number of frames after this timecode.
+func (timecode Timecode) Add(frames int, fps float64) Timecode {
+	return FrameTimecode(timecode.Frame(fps)+frames, fps, timecode.DropFrame)
+}
+
+// Returns the timecode of the given number of frames since 00:00:00:00 at the given frame rate.
+// Timecodes wrap around after 24 hours. Drop-frame counting is only defined for 29.97 and
+// 59.94 fps and ignored otherwise.
+func FrameTimecode(frame int, fps float64, dropframe bool) Timecode {
+	nominal, drop := timecodeRate(fps, dropframe)
+
+	// Frames per 24 hours.
+	day := nominal * 86400
+	if drop > 0 {
+		tenMinutes := nominal*600 - drop*9
+		day = tenMinutes * 144
+	}
+	frame = ((frame % day) + day) % day
+
+	// Add the skipped frame numbers back to count like non-drop timecode.
+	if drop > 0 {
+		tenMinutes := nominal*600 - drop*9
+		minute := nominal*60 - drop
+
+		tens := frame / tenMinutes
+		remainder := frame % tenMinutes
+		frame += drop * 9 * tens
+		if remainder >= drop {
+			frame += drop * ((remainder - drop) / minute)
+		}
+	}
+
+	return Timecode{
+		Hours:     frame / (nominal * 3600),
+		Minutes:   frame / (nominal * 60) % 60,
+		Seconds:   frame / nominal % 60,
+		Frames:    frame % nominal,
+		DropFrame: dropframe && drop > 0,
+	}
+}
+
+// Returns the nominal integer frame rate of timecodes at the given frame rate and the number
+// of frame numbers dropped per minute.
+func timecodeRate(fps float64, dropframe bool) (int, int) {
+	nominal := int(math.Round(fps))
+	if nominal < 1 {
+		nominal = 1
+	}
+	if dropframe && (nominal == 30 || nominal == 60) {
+		return nominal, nominal / 15
+	}
+	return nominal, 0
+}
+
+// Returns the start timecode of the video from the timecode tag of the file, its streams or its
+// timecode (tmcd) track. Returns nil if the video has no timecode.
+func (video *Video) StartTimecode() (*Timecode, error) {
+	data, err := probe(video.filename, "-show_format", "-show_streams")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range data {
+		if value, ok := entry["tag:timecode"]; ok && value != "" {
+			timecode, err := ParseTimecode(value)
+			if err != nil {
+				return nil, err
+			}
+			return &timecode, nil
+		}
+	}
+
+	return nil, nil
+}
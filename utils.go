@@ -2,6 +2,7 @@ package vidio
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -37,18 +38,46 @@ func installed(program string) error {
 	return nil
 }
 
+// Runs ffmpeg with the given arguments until it completes, overwriting any output files.
+// If ffmpeg fails, the returned error contains its error output.
+func ffmpeg(args ...string) error {
+	_, err := ffmpegOutput(args...)
+	return err
+}
+
+// Runs ffmpeg with the given arguments until it completes and returns what it wrote to stdout.
+// If ffmpeg fails, the returned error contains its error output.
+func ffmpegOutput(args ...string) ([]byte, error) {
+	cmd := exec.Command("ffmpeg", append([]string{"-y", "-loglevel", "error"}, args...)...)
+
+	stdout := bytes.Buffer{}
+	stderr := bytes.Buffer{}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			message = err.Error()
+		}
+		return nil, fmt.Errorf("vidio: ffmpeg failed: %s", message)
+	}
+
+	return stdout.Bytes(), nil
+}
+
 // Runs ffprobe on the given file and returns a map of the metadata.
 func ffprobe(filename, stype string) ([]map[string]string, error) {
 	// "stype" is stream stype. "v" for video, "a" for audio.
 	// Extract video information with ffprobe.
-	cmd := exec.Command(
-		"ffprobe",
-		"-show_streams",
-		"-select_streams", stype,
-		"-print_format", "compact",
-		"-loglevel", "quiet",
-		filename,
-	)
+	return probe(filename, "-show_streams", "-select_streams", stype)
+}
+
+// Runs ffprobe on the given file with the given arguments and returns a map for each
+// line of the compact output, e.g. one per stream, packet or frame.
+func probe(filename string, args ...string) ([]map[string]string, error) {
+	command := append(args, "-print_format", "compact", "-loglevel", "quiet", filename)
+	cmd := exec.Command("ffprobe", command...)
 
 	pipe, err := cmd.StdoutPipe()
 	if err != nil {
@@ -80,22 +109,42 @@ func ffprobe(filename, stype string) ([]map[string]string, error) {
 	metadata := builder.String()
 	for _, stream := range strings.Split(metadata, "\n") {
 		if len(strings.TrimSpace(stream)) > 0 {
-			data := make(map[string]string)
-			for _, line := range strings.Split(stream, "|") {
-				if strings.Contains(line, "=") {
-					keyValue := strings.Split(line, "=")
-					if _, ok := data[keyValue[0]]; !ok {
-						data[keyValue[0]] = keyValue[1]
-					}
-				}
-			}
-			datalist = append(datalist, data)
+			datalist = append(datalist, parseCompact(stream))
 		}
 	}
 
 	return datalist, nil
 }
 
+// Parses a single line of ffprobe compact output into a map of key value pairs.
+// If a key appears multiple times, the first value is kept.
+func parseCompact(line string) map[string]string {
+	data := make(map[string]string)
+	for _, field := range strings.Split(strings.TrimSpace(line), "|") {
+		if strings.Contains(field, "=") {
+			keyValue := strings.SplitN(field, "=", 2)
+			if _, ok := data[keyValue[0]]; !ok {
+				data[keyValue[0]] = keyValue[1]
+			}
+		}
+	}
+	return data
+}
+
+// Runs ffprobe on the given file with the given arguments and decodes the JSON output into "v".
+// Used for ffprobe output with nested sections, which the compact format cannot represent.
+func ffprobeJSON(filename string, v interface{}, args ...string) error {
+	command := append([]string{"-print_format", "json", "-loglevel", "quiet"}, args...)
+	cmd := exec.Command("ffprobe", append(command, filename)...)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(output, v)
+}
+
 // Parses the given data into a float64.
 func parse(data string) float64 {
 	n, err := strconv.ParseFloat(data, 64)
@@ -255,3 +304,11 @@ func buildSelectExpression(n ...int) (string, error) {
 
 	return sb.String(), nil
 }
+
+// Escapes a value, e.g. a file path, for use as a filter option in an ffmpeg filtergraph.
+// The value is escaped once for the filter options and once for the filtergraph.
+// https://ffmpeg.org/ffmpeg-filters.html#Notes-on-filtergraph-escaping.
+func escapeFilterValue(value string) string {
+	option := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(value)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(option)
+}
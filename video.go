@@ -12,21 +12,37 @@ import (
 )
 
 type Video struct {
-	filename    string            // Video Filename.
-	width       int               // Width of frames.
-	height      int               // Height of frames.
-	depth       int               // Depth of frames.
-	bitrate     int               // Bitrate for video encoding.
-	frames      int               // Total number of frames.
-	stream      int               // Stream Index.
-	duration    float64           // Duration of video in seconds.
-	fps         float64           // Frames per second.
-	codec       string            // Codec used for video encoding.
-	hasstreams  bool              // Flag storing whether file has additional data streams.
-	framebuffer []byte            // Raw frame data.
-	metadata    map[string]string // Video metadata.
-	pipe        io.ReadCloser     // Stdout pipe for ffmpeg process.
-	cmd         *exec.Cmd         // ffmpeg command.
+	filename    string             // Video Filename.
+	width       int                // Width of frames.
+	height      int                // Height of frames.
+	depth       int                // Depth of frames.
+	bitdepth    int                // Bits per channel of decoded frames (8 or 16).
+	bitrate     int                // Bitrate for video encoding.
+	frames      int                // Total number of frames.
+	stream      int                // Stream Index.
+	duration    float64            // Duration of video in seconds.
+	start       float64            // Start time of the video stream in seconds.
+	fps         float64            // Frames per second.
+	codec       string             // Codec used for video encoding.
+	pixfmt      string             // Pixel format of the encoded video stream.
+	color       Color              // Color properties of the video stream.
+	outcolor    Color              // Color properties of the decoded RGB frames.
+	tonemap     string             // Tone mapping operator used to convert HDR to SDR.
+	fieldorder  string             // Field order of interlaced video.
+	deinterlace string             // Deinterlacing mode applied during decoding.
+	hdr         *HDRMetadata       // Cached HDR mastering metadata.
+	keyframes   []Keyframe         // Cached keyframe index.
+	gop         *GOPStats          // Cached GOP statistics.
+	chapters    []Chapter          // Cached chapters.
+	format      *Metadata          // Cached format-level metadata.
+	hasstreams  bool               // Flag storing whether file has additional data streams.
+	audio       []AudioStream      // Audio streams of the file.
+	subtitles   []SubtitleStream   // Subtitle streams of the file.
+	attachments []AttachmentStream // Attachment streams of the file.
+	framebuffer []byte             // Raw frame data.
+	metadata    map[string]string  // Video metadata.
+	pipe        io.ReadCloser      // Stdout pipe for ffmpeg process.
+	cmd         *exec.Cmd          // ffmpeg command.
 }
 
 func (video *Video) FileName() string {
@@ -46,6 +62,11 @@ func (video *Video) Depth() int {
 	return video.depth
 }
 
+// Bits per channel of decoded frames. Either 8 (rgba) or 16 (rgba64).
+func (video *Video) BitDepth() int {
+	return video.bitdepth
+}
+
 // Bitrate of video in bits/s.
 func (video *Video) Bitrate() int {
 	return video.bitrate
@@ -75,6 +96,27 @@ func (video *Video) Codec() string {
 	return video.codec
 }
 
+// Pixel format of the encoded video stream, e.g. yuv420p or yuv420p10le.
+func (video *Video) PixelFormat() string {
+	return video.pixfmt
+}
+
+// Color primaries, transfer characteristics, matrix and range of the video stream.
+func (video *Video) Color() Color {
+	return video.color
+}
+
+// Color primaries and transfer characteristics the decoded RGB frames are converted to.
+// Empty fields mean no conversion.
+func (video *Video) OutputColor() Color {
+	return video.outcolor
+}
+
+// Tone mapping operator used when converting HDR frames to SDR. Empty if disabled.
+func (video *Video) ToneMap() string {
+	return video.tonemap
+}
+
 // Returns true if file has any audio, subtitle, data or attachment streams.
 func (video *Video) HasStreams() bool {
 	return video.hasstreams
@@ -90,7 +132,7 @@ func (video *Video) MetaData() map[string]string {
 }
 
 func (video *Video) SetFrameBuffer(buffer []byte) error {
-	size := video.width * video.height * video.depth
+	size := video.frameSize()
 	if len(buffer) < size {
 		return fmt.Errorf("vidio: buffer size %d is smaller than frame size %d", len(buffer), size)
 	}
@@ -98,6 +140,60 @@ func (video *Video) SetFrameBuffer(buffer []byte) error {
 	return nil
 }
 
+// Sets the number of bits per channel of decoded frames. With 8 bits, frames are
+// decoded as rgba. With 16 bits, frames are decoded as big-endian rgba64, matching
+// the layout of image.RGBA64. Must be called before the first Read().
+func (video *Video) SetBitDepth(bits int) error {
+	if bits != 8 && bits != 16 {
+		return fmt.Errorf("vidio: unsupported bit depth %d, must be 8 or 16", bits)
+	}
+	if video.bitdepth != bits {
+		video.bitdepth = bits
+		if len(video.framebuffer) != video.frameSize() {
+			video.framebuffer = nil
+		}
+	}
+	return nil
+}
+
+// Overrides the color properties of the video stream used when converting frames to RGB.
+// Only non-empty fields are overridden. Fields missing from both the probe data and the
+// override are detected automatically from the frame size and pixel format.
+func (video *Video) SetColor(color Color) {
+	for _, field := range [][2]*string{
+		{&video.color.Space, &color.Space},
+		{&video.color.Primaries, &color.Primaries},
+		{&video.color.Transfer, &color.Transfer},
+		{&video.color.Range, &color.Range},
+	} {
+		if *field[1] != "" {
+			*field[0] = *field[1]
+		}
+	}
+}
+
+// Sets the color primaries and transfer characteristics the decoded RGB frames are converted to,
+// e.g. to convert BT.601 or BT.2020 SDR content to BT.709. Uses the ffmpeg colorspace filter,
+// which does not support HDR transfer characteristics (see SetToneMap).
+// The Range field is ignored since RGB frames are always full range.
+func (video *Video) SetOutputColor(color Color) {
+	video.outcolor = color
+}
+
+// Sets the tone mapping operator used to convert HDR frames to SDR (BT.709) before
+// they are converted to RGBA. Supported operators are none, clip, linear, gamma,
+// reinhard, hable and mobius. An empty string disables tone mapping.
+// Requires ffmpeg to be built with the zscale filter (libzimg).
+func (video *Video) SetToneMap(operator string) error {
+	switch operator {
+	case "", "none", "clip", "linear", "gamma", "reinhard", "hable", "mobius":
+		video.tonemap = operator
+		return nil
+	default:
+		return fmt.Errorf("vidio: unsupported tone mapping operator %s", operator)
+	}
+}
+
 func NewVideo(filename string) (*Video, error) {
 	streams, err := NewVideoStreams(filename)
 	if streams == nil {
@@ -131,6 +227,9 @@ func NewVideoStreams(filename string) ([]*Video, error) {
 
 	// Loop over all stream types. a: Audio, s: Subtitle, d: Data, t: Attachments
 	hasstream := false
+	audio := []AudioStream{}
+	subtitles := []SubtitleStream{}
+	attachments := []AttachmentStream{}
 	for _, c := range "asdt" {
 		data, err := ffprobe(filename, string(c))
 		if err != nil {
@@ -138,18 +237,31 @@ func NewVideoStreams(filename string) ([]*Video, error) {
 		}
 		if len(data) > 0 {
 			hasstream = true
-			break
+		}
+		for i, stream := range data {
+			switch c {
+			case 'a':
+				audio = append(audio, parseAudioStream(i, stream))
+			case 's':
+				subtitles = append(subtitles, parseSubtitleStream(i, stream))
+			case 't':
+				attachments = append(attachments, parseAttachmentStream(i, stream))
+			}
 		}
 	}
 
 	streams := make([]*Video, len(videoData))
 	for i, data := range videoData {
 		video := &Video{
-			filename:   filename,
-			depth:      4,
-			stream:     i,
-			hasstreams: hasstream,
-			metadata:   data,
+			filename:    filename,
+			depth:       4,
+			bitdepth:    8,
+			stream:      i,
+			hasstreams:  hasstream,
+			metadata:    data,
+			audio:       audio,
+			subtitles:   subtitles,
+			attachments: attachments,
 		}
 
 		video.addVideoData(data)
@@ -174,6 +286,9 @@ func (video *Video) addVideoData(data map[string]string) {
 	if duration, ok := data["duration"]; ok {
 		video.duration = float64(parse(duration))
 	}
+	if start, ok := data["start_time"]; ok {
+		video.start = parse(start)
+	}
 	if frames, ok := data["nb_frames"]; ok {
 		video.frames = int(parse(frames))
 	}
@@ -189,24 +304,80 @@ func (video *Video) addVideoData(data map[string]string) {
 	if codec, ok := data["codec_name"]; ok {
 		video.codec = codec
 	}
+	if pixfmt, ok := data["pix_fmt"]; ok {
+		video.pixfmt = pixfmt
+	}
+	if fieldorder, ok := data["field_order"]; ok {
+		video.fieldorder = fieldorder
+	}
+	video.color = parseColor(data)
 }
 
-// Once the user calls Read() for the first time on a Video struct,
-// the ffmpeg command which is used to read the video is started.
-func (video *Video) init() error {
-	// If user exits with Ctrl+C, stop ffmpeg process.
-	video.cleanup()
-	// ffmpeg command to pipe video data to stdout in 8-bit RGBA format.
-	cmd := exec.Command(
-		"ffmpeg",
+// Size of a single decoded frame in bytes.
+func (video *Video) frameSize() int {
+	return video.width * video.height * video.depth * video.bitdepth / 8
+}
+
+// Raw pixel format used for decoded frames.
+func (video *Video) outputFormat() string {
+	if video.bitdepth == 16 {
+		return "rgba64be"
+	}
+	return "rgba"
+}
+
+// Returns the filters applied to every decoded frame, in order.
+func (video *Video) filters() []string {
+	filters := []string{}
+	if video.deinterlace != "" {
+		filters = append(filters, deinterlaceFilters[video.deinterlace])
+	}
+	if video.tonemap != "" {
+		filters = append(filters, toneMapFilter(video.tonemap))
+	} else if filter := colorspaceFilter(video.inputColor(), video.outcolor); filter != "" {
+		filters = append(filters, filter)
+	}
+	return filters
+}
+
+// Returns the color properties of the video stream with missing values detected
+// automatically. Tone mapped video is always BT.709 limited range.
+func (video *Video) inputColor() Color {
+	if video.tonemap != "" {
+		return Color{Space: "bt709", Primaries: "bt709", Transfer: "bt709", Range: "tv"}
+	}
+	return detectColor(video.color, video.width, video.height, video.pixfmt)
+}
+
+// Builds the ffmpeg arguments used to decode the video stream into raw frames of
+// the given pixel format. The given filters are applied after the filters configured
+// on the video, e.g. a "select" filter used to pick specific frames.
+func (video *Video) command(pixfmt string, filters ...string) []string {
+	command := []string{
 		"-i", video.filename,
 		"-f", "image2pipe",
 		"-loglevel", "quiet",
-		"-pix_fmt", "rgba",
+		"-pix_fmt", pixfmt,
 		"-vcodec", "rawvideo",
 		"-map", fmt.Sprintf("0:v:%d", video.stream),
-		"-",
-	)
+	}
+
+	filters = append(video.filters(), filters...)
+	// Convert to RGB with the correct matrix and range instead of the swscale defaults.
+	filters = append(filters, rgbFilter(video.inputColor()), "format="+pixfmt)
+	command = append(command, "-vf", strings.Join(filters, ","))
+
+	return command
+}
+
+// Once the user calls Read() for the first time on a Video struct,
+// the ffmpeg command which is used to read the video is started.
+func (video *Video) init() error {
+	// If user exits with Ctrl+C, stop ffmpeg process.
+	video.cleanup()
+	// ffmpeg command to pipe video data to stdout in 8-bit RGBA or 16-bit RGBA64 format.
+	command := append(video.command(video.outputFormat()), "-")
+	cmd := exec.Command("ffmpeg", command...)
 
 	video.cmd = cmd
 	pipe, err := cmd.StdoutPipe()
@@ -220,7 +391,7 @@ func (video *Video) init() error {
 	}
 
 	if video.framebuffer == nil {
-		video.framebuffer = make([]byte, video.width*video.height*video.depth)
+		video.framebuffer = make([]byte, video.frameSize())
 	}
 
 	return nil
@@ -236,7 +407,7 @@ func (video *Video) Read() bool {
 		}
 	}
 
-	if _, err := io.ReadFull(video.pipe, video.framebuffer); err != nil {
+	if _, err := io.ReadFull(video.pipe, video.framebuffer[:video.frameSize()]); err != nil {
 		video.Close()
 		return false
 	}
@@ -251,102 +422,77 @@ func (video *Video) ReadFrame(n int) error {
 	}
 
 	if video.framebuffer == nil {
-		video.framebuffer = make([]byte, video.width*video.height*video.depth)
+		video.framebuffer = make([]byte, video.frameSize())
 	}
 
-	selectExpression, err := buildSelectExpression(n)
-	if err != nil {
-		return fmt.Errorf("vidio: failed to parse the specified frame index: %w", err)
-	}
+	return video.readFrames(video.outputFormat(), [][]byte{video.framebuffer[:video.frameSize()]}, n)
+}
 
-	cmd := exec.Command(
-		"ffmpeg",
-		"-i", video.filename,
-		"-f", "image2pipe",
-		"-loglevel", "quiet",
-		"-pix_fmt", "rgba",
-		"-vcodec", "rawvideo",
-		"-map", fmt.Sprintf("0:v:%d", video.stream),
-		"-vf", selectExpression,
-		"-vsync", "0",
-		"-",
-	)
+// Read the N-amount of frames with the given indexes and return them as a slice of RGBA image pointers. If one of
+// the indexes is out of range, the function will return an error. The frames are indexes from 0.
+func (video *Video) ReadFrames(n ...int) ([]*image.RGBA, error) {
+	if len(n) == 0 {
+		return nil, fmt.Errorf("vidio: no frames indexes specified")
+	}
 
-	stdoutPipe, err := cmd.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("vidio: failed to access the ffmpeg stdout pipe: %w", err)
+	frames := make([]*image.RGBA, len(n))
+	buffers := make([][]byte, len(n))
+	for frameIndex := range frames {
+		frames[frameIndex] = image.NewRGBA(image.Rect(0, 0, video.width, video.height))
+		buffers[frameIndex] = frames[frameIndex].Pix
 	}
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("vidio: failed to start the ffmpeg cmd: %w", err)
+	if err := video.readFrames("rgba", buffers, n...); err != nil {
+		return nil, err
 	}
 
-	interruptChan := make(chan os.Signal, 1)
-	signal.Notify(interruptChan, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-interruptChan
-		if stdoutPipe != nil {
-			stdoutPipe.Close()
-		}
-		if cmd != nil {
-			cmd.Process.Kill()
-		}
-		os.Exit(1)
-	}()
+	return frames, nil
+}
 
-	if _, err := io.ReadFull(stdoutPipe, video.framebuffer); err != nil {
-		return fmt.Errorf("vidio: failed to read the ffmpeg cmd result to the image buffer: %w", err)
+// Read the N-amount of frames with the given indexes and return them as a slice of 16-bit RGBA64 image pointers.
+// If one of the indexes is out of range, the function will return an error. The frames are indexes from 0.
+func (video *Video) ReadFrames64(n ...int) ([]*image.RGBA64, error) {
+	if len(n) == 0 {
+		return nil, fmt.Errorf("vidio: no frames indexes specified")
 	}
 
-	if err := stdoutPipe.Close(); err != nil {
-		return fmt.Errorf("vidio: failed to close the ffmpeg stdout pipe: %w", err)
+	frames := make([]*image.RGBA64, len(n))
+	buffers := make([][]byte, len(n))
+	for frameIndex := range frames {
+		frames[frameIndex] = image.NewRGBA64(image.Rect(0, 0, video.width, video.height))
+		buffers[frameIndex] = frames[frameIndex].Pix
 	}
 
-	if err := cmd.Wait(); err != nil {
-		return fmt.Errorf("vidio: failed to free resources after the ffmpeg cmd: %w", err)
+	if err := video.readFrames("rgba64be", buffers, n...); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return frames, nil
 }
 
-// Read the N-amount of frames with the given indexes and return them as a slice of RGBA image pointers. If one of
-// the indexes is out of range, the function will return an error. The frames are indexes from 0.
-func (video *Video) ReadFrames(n ...int) ([]*image.RGBA, error) {
-	if len(n) == 0 {
-		return nil, fmt.Errorf("vidio: no frames indexes specified")
-	}
-
+// Decodes the frames with the given indexes in the given pixel format into the given buffers.
+func (video *Video) readFrames(pixfmt string, buffers [][]byte, n ...int) error {
 	for _, nValue := range n {
 		if nValue >= video.frames {
-			return nil, fmt.Errorf("vidio: provided frame index %d is not in frame count range", nValue)
+			return fmt.Errorf("vidio: provided frame index %d is not in frame count range", nValue)
 		}
 	}
 
 	selectExpression, err := buildSelectExpression(n...)
 	if err != nil {
-		return nil, fmt.Errorf("vidio: failed to parse the specified frame index: %w", err)
+		return fmt.Errorf("vidio: failed to parse the specified frame index: %w", err)
 	}
 
-	cmd := exec.Command(
-		"ffmpeg",
-		"-i", video.filename,
-		"-f", "image2pipe",
-		"-loglevel", "quiet",
-		"-pix_fmt", "rgba",
-		"-vcodec", "rawvideo",
-		"-map", fmt.Sprintf("0:v:%d", video.stream),
-		"-vf", selectExpression,
-		"-vsync", "0",
-		"-",
-	)
+	command := append(video.command(pixfmt, selectExpression), "-vsync", "0", "-")
+	cmd := exec.Command("ffmpeg", command...)
 
 	stdoutPipe, err := cmd.StdoutPipe()
 	if err != nil {
-		return nil, fmt.Errorf("vidio: failed to access the ffmpeg stdout pipe: %w", err)
+		return fmt.Errorf("vidio: failed to access the ffmpeg stdout pipe: %w", err)
 	}
 
 	if err := cmd.Start(); err != nil {
-		return nil, fmt.Errorf("vidio: failed to start the ffmpeg cmd: %w", err)
+		return fmt.Errorf("vidio: failed to start the ffmpeg cmd: %w", err)
 	}
 
 	interruptChan := make(chan os.Signal, 1)
@@ -362,24 +508,21 @@ func (video *Video) ReadFrames(n ...int) ([]*image.RGBA, error) {
 		os.Exit(1)
 	}()
 
-	frames := make([]*image.RGBA, len(n))
-	for frameIndex := range frames {
-		frames[frameIndex] = image.NewRGBA(image.Rect(0, 0, video.width, video.height))
-
-		if _, err := io.ReadFull(stdoutPipe, frames[frameIndex].Pix); err != nil {
-			return nil, fmt.Errorf("vidio: failed to read the ffmpeg cmd result to the image buffer: %w", err)
+	for _, buffer := range buffers {
+		if _, err := io.ReadFull(stdoutPipe, buffer); err != nil {
+			return fmt.Errorf("vidio: failed to read the ffmpeg cmd result to the image buffer: %w", err)
 		}
 	}
 
 	if err := stdoutPipe.Close(); err != nil {
-		return nil, fmt.Errorf("vidio: failed to close the ffmpeg stdout pipe: %w", err)
+		return fmt.Errorf("vidio: failed to close the ffmpeg stdout pipe: %w", err)
 	}
 
 	if err := cmd.Wait(); err != nil {
-		return nil, fmt.Errorf("vidio: failed to free resources after the ffmpeg cmd: %w", err)
+		return fmt.Errorf("vidio: failed to free resources after the ffmpeg cmd: %w", err)
 	}
 
-	return frames, nil
+	return nil
 }
 
 // Closes the pipe and stops the ffmpeg process.
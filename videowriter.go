@@ -7,36 +7,103 @@ import (
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 )
 
 type VideoWriter struct {
-	filename   string         // Output filename.
-	streamfile string         // Extra stream data filename.
-	width      int            // Frame width.
-	height     int            // Frame height.
-	bitrate    int            // Output video bitrate.
-	loop       int            // Number of times for GIF to loop.
-	delay      int            // Delay of final frame of GIF. Default -1 (same delay as previous frame).
-	macro      int            // Macroblock size for determining how to resize frames for codecs.
-	fps        float64        // Frames per second for output video. Default 25.
-	quality    float64        // Used if bitrate not given. Default 0.5.
-	codec      string         // Codec to encode video with. Default libx264.
-	pipe       io.WriteCloser // Stdout pipe of ffmpeg process.
-	cmd        *exec.Cmd      // ffmpeg command.
+	filename   string                       // Output filename.
+	streamfile string                       // Extra stream data filename.
+	streams    []StreamFile                 // Files with extra streams, including the stream data file.
+	length     string                       // Policy for the output length with audio or extra streams.
+	width      int                          // Frame width.
+	height     int                          // Frame height.
+	bitrate    int                          // Output video bitrate.
+	maxrate    int                          // Maximum video bitrate of the rate control buffer. 0 if unconstrained.
+	bufsize    int                          // Size of the rate control buffer in bits.
+	cbr        bool                         // Encode with a constant bitrate.
+	twopass    bool                         // Encode in two passes when the writer is closed.
+	buffered   bool                         // Buffer the frames and audio in temporary files and encode them when the writer is closed.
+	forcekeys  string                       // Expression of the frames forced to be keyframes.
+	keyframes  []int                        // Frames forced to be keyframes, including those written with WriteKeyframe().
+	frames     int                          // Number of frames written.
+	timed      bool                         // Frames are written with timestamps by WriteAt().
+	pts        time.Duration                // Timestamp of the last frame written with WriteAt().
+	mkv        *matroska                    // Matroska stream passing the timestamped frames to ffmpeg.
+	loop       int                          // Number of times for GIF to loop.
+	delay      int                          // Delay of final frame of GIF. Default -1 (same delay as previous frame).
+	macro      int                          // Macroblock size for determining how to resize frames for codecs.
+	fps        float64                      // Frames per second for output video. Default 25.
+	quality    float64                      // Used if bitrate not given. Default 0.5.
+	codec      string                       // Codec to encode video with. Default libx264.
+	settings   encoderSettings              // Preset, tuning, profile, level and GOP settings of the encoder.
+	color      Color                        // Color properties of the output video.
+	samplerate int                          // Sample rate of the audio written with WriteAudio(). 0 if disabled.
+	channels   int                          // Number of audio channels. Default 2.
+	sampleform string                       // Sample format of the audio. s16le or f32le. Default s16le.
+	acodec     string                       // Codec to encode audio with. Default aac.
+	subtitles  []Cue                        // Subtitle cues to add to the video.
+	subfile    string                       // Subtitle file to add to the video.
+	burn       bool                         // Render the subtitles into the frames instead of muxing them.
+	scodec     string                       // Codec of the muxed subtitle stream.
+	chapters   []Chapter                    // Chapters of the video.
+	timecode   string                       // Start timecode of the video.
+	rotation   int                          // Clockwise display rotation of the video in degrees.
+	metadata   map[string]string            // Format-level metadata tags.
+	streammeta map[string]map[string]string // Stream metadata tags by stream specifier.
+	tempfiles  []string                     // Temporary subtitle, chapter, frame and audio files.
+	framefile  string                       // Temporary file buffering the frames for two-pass encoding.
+	audiofile  string                       // Temporary file buffering the audio for two-pass encoding.
+	pipe       io.WriteCloser               // Stdout pipe of ffmpeg process.
+	audio      io.WriteCloser               // Audio pipe of ffmpeg process.
+	cmd        *exec.Cmd                    // ffmpeg command.
 }
 
 // Optional parameters for VideoWriter.
 type Options struct {
-	Bitrate    int     // Bitrate.
-	Loop       int     // For GIFs only. -1=no loop, 0=infinite loop, >0=number of loops.
-	Delay      int     // Delay for final frame of GIFs in centiseconds.
-	Macro      int     // Macroblock size for determining how to resize frames for codecs.
-	FPS        float64 // Frames per second for output video.
-	Quality    float64 // If bitrate not given, use quality instead. Must be between 0 and 1. 0:best, 1:worst.
-	Codec      string  // Codec for video.
-	StreamFile string  // File path for extra stream data.
+	Bitrate          int                          // Bitrate.
+	MaxRate          int                          // Maximum bitrate in bits/s the rate control may reach. Default unconstrained.
+	BufSize          int                          // Rate control buffer size in bits. Default twice MaxRate, or Bitrate with CBR.
+	CBR              bool                         // Encode with a constant bitrate. Requires Bitrate.
+	TwoPass          bool                         // Buffer the frames in a temporary file and encode them in two passes on Close(). Requires Bitrate.
+	Loop             int                          // For GIFs only. -1=no loop, 0=infinite loop, >0=number of loops.
+	Delay            int                          // Delay for final frame of GIFs in centiseconds.
+	Macro            int                          // Macroblock size for determining how to resize frames for codecs.
+	FPS              float64                      // Frames per second for output video.
+	Quality          float64                      // If bitrate not given, use quality instead. Must be between 0 and 1. 0:best, 1:worst.
+	Codec            string                       // Codec for video.
+	Preset           string                       // Encoder preset, e.g. medium for libx264 or good for libvpx-vp9.
+	Tune             string                       // Encoder tuning, e.g. film.
+	Profile          string                       // Encoder profile, e.g. high.
+	Level            string                       // Encoder level, e.g. 4.1.
+	KeyframeInterval int                          // Maximum number of frames between keyframes. Default is the encoder default.
+	BFrames          int                          // Maximum number of consecutive B-frames. -1 disables B-frames. Default is the encoder default.
+	RefFrames        int                          // Number of reference frames. Default is the encoder default.
+	ClosedGOP        bool                         // Close every GOP, so segments can be decoded on their own.
+	FixedGOP         bool                         // Place keyframes exactly every KeyframeInterval frames, without scene cut keyframes.
+	ForceKeyframes   string                       // Frames forced to be keyframes as an ffmpeg -force_key_frames expression, e.g. expr:gte(t,n_forced*2).
+	Keyframes        []int                        // Zero-indexed frames forced to be keyframes. Cannot be used with ForceKeyframes.
+	BufferFrames     bool                         // Buffer the raw frames in a temporary file (width*height*4 bytes per frame) and encode them on Close(). Required by WriteKeyframe().
+	CodecParams      map[string]string            // Codec-private parameters, e.g. x264-params. Override the settings above.
+	StreamFile       string                       // File path for extra stream data.
+	StreamFiles      []StreamFile                 // Files with extra streams, added after StreamFile.
+	Length           string                       // Output length policy with audio or extra streams. LengthShortest, LengthLongest, LengthVideo or LengthAudio.
+	Color            Color                        // Color matrix, range, primaries and transfer to convert to and tag the output with.
+	SampleRate       int                          // Sample rate of the audio written with WriteAudio(). Audio is disabled if 0.
+	Channels         int                          // Number of audio channels. Default 2.
+	AudioFormat      string                       // Sample format of the audio: s16le (default) or f32le.
+	AudioCodec       string                       // Codec for audio.
+	Subtitles        []Cue                        // Subtitle cues to add to the video.
+	SubtitleFile     string                       // SRT, ASS or WebVTT file to add to the video. Cannot be used with Subtitles.
+	BurnSubtitles    bool                         // Render the subtitles into the frames instead of adding a subtitle stream.
+	Chapters         []Chapter                    // Chapters of the video.
+	Timecode         string                       // Start timecode of the video, e.g. 01:00:00:00 or 01:00:00;00 for drop-frame.
+	Rotation         int                          // Clockwise rotation in degrees players apply when displaying the video. Requires FFmpeg 6.0 or later.
+	Metadata         map[string]string            // Format-level metadata tags, e.g. title.
+	StreamMetadata   map[string]map[string]string // Stream metadata tags keyed by stream specifier, e.g. a:0.
 }
 
 func (writer *VideoWriter) FileName() string {
@@ -48,6 +115,16 @@ func (writer *VideoWriter) StreamFile() string {
 	return writer.streamfile
 }
 
+// Files used to fill in extra streams, including the stream data file.
+func (writer *VideoWriter) StreamFiles() []StreamFile {
+	return writer.streams
+}
+
+// Policy determining the output length with audio or extra streams.
+func (writer *VideoWriter) Length() string {
+	return writer.length
+}
+
 func (writer *VideoWriter) Width() int {
 	return writer.width
 }
@@ -90,6 +167,155 @@ func (writer *VideoWriter) Codec() string {
 	return writer.codec
 }
 
+// Encoder preset. Empty if the encoder default is used.
+func (writer *VideoWriter) Preset() string {
+	return writer.settings.preset
+}
+
+// Encoder tuning. Empty if the encoder default is used.
+func (writer *VideoWriter) Tune() string {
+	return writer.settings.tune
+}
+
+// Encoder profile. Empty if the encoder default is used.
+func (writer *VideoWriter) Profile() string {
+	return writer.settings.profile
+}
+
+// Encoder level. Empty if the encoder default is used.
+func (writer *VideoWriter) Level() string {
+	return writer.settings.level
+}
+
+// Maximum number of frames between keyframes. 0 if the encoder default is used.
+func (writer *VideoWriter) KeyframeInterval() int {
+	return writer.settings.keyint
+}
+
+// Maximum number of consecutive B-frames. 0 if the encoder default is used, -1 if disabled.
+func (writer *VideoWriter) BFrames() int {
+	return writer.settings.bframes
+}
+
+// Number of reference frames. 0 if the encoder default is used.
+func (writer *VideoWriter) RefFrames() int {
+	return writer.settings.refs
+}
+
+// True if every GOP is closed.
+func (writer *VideoWriter) ClosedGOP() bool {
+	return writer.settings.closed
+}
+
+// True if keyframes are placed exactly every KeyframeInterval() frames.
+func (writer *VideoWriter) FixedGOP() bool {
+	return writer.settings.fixed
+}
+
+// Expression of the frames forced to be keyframes.
+func (writer *VideoWriter) ForceKeyframes() string {
+	return writer.forcekeys
+}
+
+// Indices of the frames forced to be keyframes by Options.Keyframes and WriteKeyframe().
+func (writer *VideoWriter) Keyframes() []int {
+	return writer.keyframes
+}
+
+// Codec-private parameters of the encoder.
+func (writer *VideoWriter) CodecParams() map[string]string {
+	return writer.settings.params
+}
+
+// Maximum video bitrate in bits/s. 0 if unconstrained.
+func (writer *VideoWriter) MaxRate() int {
+	return writer.maxrate
+}
+
+// Rate control buffer size in bits. 0 if the encoder default is used.
+func (writer *VideoWriter) BufSize() int {
+	return writer.bufsize
+}
+
+// True if the video is encoded with a constant bitrate.
+func (writer *VideoWriter) CBR() bool {
+	return writer.cbr
+}
+
+// True if the video is encoded in two passes.
+func (writer *VideoWriter) TwoPass() bool {
+	return writer.twopass
+}
+
+// True if the raw frames are buffered in a temporary file and encoded on Close().
+func (writer *VideoWriter) BufferFrames() bool {
+	return writer.buffered
+}
+
+// Color properties of the output video. Empty if the encoder defaults are used.
+func (writer *VideoWriter) Color() Color {
+	return writer.color
+}
+
+// Sample rate of the audio written with WriteAudio(). 0 if audio is disabled.
+func (writer *VideoWriter) SampleRate() int {
+	return writer.samplerate
+}
+
+// Number of audio channels.
+func (writer *VideoWriter) Channels() int {
+	return writer.channels
+}
+
+// Sample format of the audio written with WriteAudio().
+func (writer *VideoWriter) AudioFormat() string {
+	return writer.sampleform
+}
+
+func (writer *VideoWriter) AudioCodec() string {
+	return writer.acodec
+}
+
+// Subtitle cues added to the video.
+func (writer *VideoWriter) Subtitles() []Cue {
+	return writer.subtitles
+}
+
+// Subtitle file added to the video.
+func (writer *VideoWriter) SubtitleFile() string {
+	return writer.subfile
+}
+
+// Returns true if the subtitles are rendered into the frames.
+func (writer *VideoWriter) BurnSubtitles() bool {
+	return writer.burn
+}
+
+// Chapters of the video.
+func (writer *VideoWriter) Chapters() []Chapter {
+	return writer.chapters
+}
+
+// Start timecode of the video.
+func (writer *VideoWriter) Timecode() string {
+	return writer.timecode
+}
+
+// Clockwise display rotation of the video in degrees.
+func (writer *VideoWriter) Rotation() int {
+	return writer.rotation
+}
+
+// Format-level metadata tags of the video.
+func (writer *VideoWriter) Metadata() map[string]string {
+	return writer.metadata
+}
+
+// Stream metadata tags of the video keyed by stream specifier.
+func (writer *VideoWriter) StreamMetadata() map[string]map[string]string {
+	return writer.streammeta
+}
+
 // Creates a new VideoWriter struct with default values from the Options struct.
 func NewVideoWriter(filename string, width, height int, options *Options) (*VideoWriter, error) {
 	// Check if ffmpeg is installed on the users machine.
@@ -106,6 +332,12 @@ func NewVideoWriter(filename string, width, height int, options *Options) (*Vide
 		width:    width,
 		height:   height,
 		bitrate:  options.Bitrate,
+		maxrate:  options.MaxRate,
+		bufsize:  options.BufSize,
+		cbr:      options.CBR,
+		twopass:  options.TwoPass,
+		buffered: options.TwoPass || options.BufferFrames,
+		color:    options.Color,
 	}
 
 	// Default Parameter options logic from:
@@ -149,11 +381,129 @@ func NewVideoWriter(filename string, width, height int, options *Options) (*Vide
 		writer.codec = options.Codec
 	}
 
-	if options.StreamFile != "" {
-		if !exists(options.StreamFile) {
-			return nil, fmt.Errorf("vidio: file %s does not exist", options.StreamFile)
+	writer.settings = encoderSettings{
+		preset:  options.Preset,
+		tune:    options.Tune,
+		profile: options.Profile,
+		level:   options.Level,
+		keyint:  options.KeyframeInterval,
+		bframes: options.BFrames,
+		refs:    options.RefFrames,
+		closed:  options.ClosedGOP,
+		fixed:   options.FixedGOP,
+		params:  options.CodecParams,
+	}
+	// Check that the encoder supports the settings.
+	if _, err := encoderArgs(writer.codec, writer.settings); err != nil {
+		return nil, err
+	}
+
+	if writer.maxrate < 0 || writer.bufsize < 0 {
+		return nil, fmt.Errorf("vidio: maximum bitrate and buffer size must not be negative")
+	}
+	if (writer.cbr || writer.twopass) && writer.bitrate <= 0 {
+		return nil, fmt.Errorf("vidio: constant bitrate and two-pass encoding require a bitrate")
+	}
+	if writer.twopass && !twoPassSupported(writer.codec) {
+		return nil, fmt.Errorf("vidio: encoder %s does not support two-pass encoding", writer.codec)
+	}
+
+	if options.ForceKeyframes != "" && len(options.Keyframes) > 0 {
+		return nil, fmt.Errorf("vidio: keyframes cannot be forced by both an expression and frame indices")
+	}
+	for _, frame := range options.Keyframes {
+		if frame < 0 {
+			return nil, fmt.Errorf("vidio: keyframe index %d must not be negative", frame)
+		}
+	}
+	writer.forcekeys = options.ForceKeyframes
+	writer.keyframes = append([]int(nil), options.Keyframes...)
+
+	streams, err := streamFiles(options)
+	if err != nil {
+		return nil, err
+	}
+	writer.streamfile = options.StreamFile
+	writer.streams = streams
+
+	length, err := lengthPolicy(options.Length, streams)
+	if err != nil {
+		return nil, err
+	}
+	writer.length = length
+
+	if options.AudioCodec == "" {
+		writer.acodec = audioEncoder(filename)
+	} else {
+		writer.acodec = options.AudioCodec
+	}
+
+	if len(options.Subtitles) > 0 || options.SubtitleFile != "" {
+		if len(options.Subtitles) > 0 && options.SubtitleFile != "" {
+			return nil, fmt.Errorf("vidio: only one of Subtitles and SubtitleFile can be given")
+		}
+
+		source := ".srt"
+		if options.SubtitleFile != "" {
+			if !exists(options.SubtitleFile) {
+				return nil, fmt.Errorf("vidio: file %s does not exist", options.SubtitleFile)
+			}
+			source = strings.ToLower(filepath.Ext(options.SubtitleFile))
+		}
+
+		writer.subtitles = options.Subtitles
+		writer.subfile = options.SubtitleFile
+		writer.burn = options.BurnSubtitles
+
+		if !writer.burn {
+			scodec, err := subtitleCodec(filename, source)
+			if err != nil {
+				return nil, err
+			}
+			writer.scodec = scodec
+		}
+	}
+
+	if err := validateChapters(options.Chapters); err != nil {
+		return nil, err
+	}
+	writer.chapters = options.Chapters
+
+	if options.Timecode != "" {
+		timecode, err := ParseTimecode(options.Timecode)
+		if err != nil {
+			return nil, err
+		}
+		if err := timecode.Valid(writer.fps); err != nil {
+			return nil, err
+		}
+		writer.timecode = timecode.String()
+	}
+
+	writer.rotation = ((options.Rotation % 360) + 360) % 360
+	writer.metadata = options.Metadata
+	writer.streammeta = options.StreamMetadata
+
+	if options.SampleRate > 0 {
+		if strings.HasSuffix(strings.ToLower(filename), ".gif") {
+			return nil, fmt.Errorf("vidio: GIFs do not support audio")
+		}
+		writer.samplerate = options.SampleRate
+
+		if options.Channels == 0 {
+			writer.channels = 2
+		} else {
+			writer.channels = options.Channels
+		}
+
+		switch options.AudioFormat {
+		case "":
+			writer.sampleform = "s16le"
+		case "s16le", "f32le":
+			writer.sampleform = options.AudioFormat
+		default:
+			return nil, fmt.Errorf("vidio: unsupported sample format %s", options.AudioFormat)
 		}
-		writer.streamfile = options.StreamFile
 	}
 
 	return writer, nil
@@ -164,60 +514,288 @@ func NewVideoWriter(filename string, width, height int, options *Options) (*Vide
 func (writer *VideoWriter) init() error {
 	// If user exits with Ctrl+C, stop ffmpeg process.
 	writer.cleanup()
-	// ffmpeg command to write to video file. Takes in bytes from Stdin and encodes them.
+
+	// With two-pass encoding or Options.BufferFrames, the frames and audio are buffered
+	// in temporary files and encoded when the writer is closed.
+	if writer.buffered {
+		return writer.initBuffered()
+	}
+
+	var samples *audioPipe
+	input := ""
+	if writer.samplerate > 0 {
+		pipe, err := newAudioPipe()
+		if err != nil {
+			return err
+		}
+		samples, input = pipe, pipe.input()
+	}
+
+	command, err := writer.command("-", input, 0, "")
+	if err != nil {
+		if samples != nil {
+			samples.Close()
+		}
+		return err
+	}
+
+	cmd := exec.Command("ffmpeg", command...)
+	if samples != nil {
+		samples.attach(cmd)
+	}
+
+	stdin, err := cmd.StdinPipe()
+	if err == nil {
+		if err = cmd.Start(); err != nil {
+			stdin.Close()
+		}
+	}
+	if err != nil {
+		if samples != nil {
+			samples.Close()
+		}
+		return err
+	}
+
+	// The writer is only set up once everything succeeded, so a failed start is retried
+	// by the next Write() or WriteAudio().
+	var pipe, audio io.WriteCloser = stdin, nil
+	if samples != nil {
+		// ffmpeg reads from both inputs in timestamp order. Queue the writes so that writing to
+		// one pipe does not block while ffmpeg is waiting for data on the other.
+		pipe = newAsyncPipe(stdin, asyncPipeLimit)
+		audio = newAsyncPipe(samples.open(cmd.Process), asyncPipeLimit)
+	}
+
+	mkv, err := writer.timedStream(pipe)
+	if err != nil {
+		cmd.Process.Kill()
+		pipe.Close()
+		if audio != nil {
+			audio.Close()
+		}
+		cmd.Wait()
+		return err
+	}
+
+	writer.cmd = cmd
+	writer.pipe = pipe
+	writer.audio = audio
+	writer.mkv = mkv
+	writer.width, writer.height = writer.macroSize()
+
+	return nil
+}
+
+// Creates the temporary files buffering the frames and audio until Close().
+func (writer *VideoWriter) initBuffered() error {
+	frames, err := writer.tempFile("vidio-*.rgba", "")
+	if err != nil {
+		return err
+	}
+	pipe, err := os.OpenFile(frames, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	samples := ""
+	var audio io.WriteCloser
+	if writer.samplerate > 0 {
+		if samples, err = writer.tempFile("vidio-*.pcm", ""); err != nil {
+			pipe.Close()
+			return err
+		}
+		if audio, err = os.OpenFile(samples, os.O_WRONLY, 0); err != nil {
+			pipe.Close()
+			return err
+		}
+	}
+
+	mkv, err := writer.timedStream(pipe)
+	if err != nil {
+		pipe.Close()
+		if audio != nil {
+			audio.Close()
+		}
+		return err
+	}
+
+	writer.framefile = frames
+	writer.audiofile = samples
+	writer.pipe = pipe
+	writer.audio = audio
+	writer.mkv = mkv
+
+	return nil
+}
+
+// Starts the Matroska stream carrying the frames on the given pipe if they are written with
+// WriteAt(), since raw frames have no timestamps. Returns nil if the frames are not timed.
+func (writer *VideoWriter) timedStream(pipe io.Writer) (*matroska, error) {
+	if !writer.timed {
+		return nil, nil
+	}
+	return newMatroska(pipe, "rawvideo", writer.width, writer.height, nil, []byte("RGBA"))
+}
+
+// Returns the ffmpeg command reading the frames and audio from the given inputs. "pass" is the
+// pass of two-pass encoding with the pass log file prefix "log", or 0 for single pass encoding.
+func (writer *VideoWriter) command(video, audio string, pass int, log string) ([]string, error) {
+	// ffmpeg command to write to video file. Takes in bytes from the video input and encodes them.
 	command := []string{
 		"-y", // overwrite output file if it exists.
 		"-loglevel", "quiet",
-		"-f", "rawvideo",
-		"-vcodec", "rawvideo",
-		"-s", fmt.Sprintf("%dx%d", writer.width, writer.height), // frame w x h.
-		"-pix_fmt", "rgba",
-		"-r", fmt.Sprintf("%.02f", writer.fps), // frames per second.
-		"-i", "-", // The input comes from stdin.
 	}
 
+	if writer.timed {
+		// Frames written with WriteAt() carry their timestamps in a Matroska stream.
+		command = append(command, "-f", "matroska")
+	} else {
+		command = append(
+			command,
+			"-f", "rawvideo",
+			"-vcodec", "rawvideo",
+			"-s", fmt.Sprintf("%dx%d", writer.width, writer.height), // frame w x h.
+			"-pix_fmt", "rgba",
+			"-r", fmt.Sprintf("%.02f", writer.fps), // frames per second.
+		)
+	}
+	command = append(command, rotationArgs(writer.rotation)...)
+	command = append(command, "-i", video) // The input usually comes from stdin.
+
 	gif := strings.HasSuffix(strings.ToLower(writer.filename), ".gif")
 
-	// Assumes "writer.streamfile" is a container format.
-	// gif check is included since they are a common format.
-	if writer.streamfile != "" && !gif {
+	// The audio from WriteAudio() usually comes from file descriptor 3, or a named pipe on Windows.
+	if writer.samplerate > 0 {
 		command = append(
 			command,
-			"-i", writer.streamfile,
-			"-map", "0:v:0",
-			"-map", "1:a?", // Add Audio streams if present.
-			"-c:a", "copy",
-			"-map", "1:s?", // Add Subtitle streams if present.
-			"-c:s", "copy",
-			"-map", "1:d?", // Add Data streams if present.
-			"-c:d", "copy",
-			"-map", "1:t?", // Add Attachments streams if present.
-			"-c:t", "copy",
-			"-shortest", // Cut longest streams to match audio duration.
+			"-f", writer.sampleform,
+			"-ar", fmt.Sprintf("%d", writer.samplerate),
+			"-ac", fmt.Sprintf("%d", writer.channels),
+			"-i", audio,
 		)
 	}
 
+	// Assumes the stream files are container formats.
+	// gif check is included since they are a common format.
+	streams := writer.streams
+	if gif {
+		streams = nil
+	}
+
+	// Subtitles given as cues are written to a temporary SRT file.
+	subtitles := writer.subfile
+	if len(writer.subtitles) > 0 {
+		file, err := writer.tempFile("vidio-*.srt", formatSRT(writer.subtitles))
+		if err != nil {
+			return nil, err
+		}
+		subtitles = file
+	}
+	soft := subtitles != "" && !writer.burn
+
+	// Chapters are read from a temporary ffmetadata file.
+	chapters := ""
+	if len(writer.chapters) > 0 {
+		file, err := writer.tempFile("vidio-*.txt", formatChapters(writer.chapters))
+		if err != nil {
+			return nil, err
+		}
+		chapters = file
+	}
+
+	// With extra inputs, the video is output 0, followed by the audio, the extra streams
+	// and the subtitles.
+	extra := writer.samplerate > 0 || len(streams) > 0
+	if extra || soft || chapters != "" {
+		first := 1
+		maps := []string{"-map", "0:v:0"}
+		if writer.samplerate > 0 {
+			maps = append(maps, "-map", "1:a:0", "-c:1", writer.acodec)
+			first++
+		}
+
+		// Padding the audio requires re-encoding it.
+		acodec := ""
+		if writer.length == LengthVideo {
+			acodec = writer.acodec
+		}
+
+		inputs, args, output, err := streamFileArgs(streams, first, first, acodec)
+		if err != nil {
+			return nil, err
+		}
+		command = append(command, inputs...)
+
+		input := first + len(streams)
+		if soft {
+			command = append(command, "-i", subtitles)
+			args = append(
+				args,
+				"-map", fmt.Sprintf("%d:s:0", input),
+				fmt.Sprintf("-c:%d", output), writer.scodec,
+			)
+			input++
+		}
+		if chapters != "" {
+			command = append(command, "-f", "ffmetadata", "-i", chapters)
+			args = append(args, "-map_chapters", fmt.Sprintf("%d", input))
+		}
+		command = append(command, maps...)
+		command = append(command, args...)
+
+		if extra {
+			command = append(command, lengthArgs(writer.length)...)
+		}
+	}
+
 	command = append(
 		command,
 		"-vcodec", writer.codec,
 		"-pix_fmt", "yuv420p", // Output is 8-bit RGB, ignore alpha.
 	)
 
+	// Convert RGB to YUV with the given matrix and range and tag the output stream.
+	scale, tags := writerColor(writer.color)
+	command = append(command, tags...)
+
 	// Code from the imageio-ffmpeg project.
 	// https://github.com/imageio/imageio-ffmpeg/blob/master/imageio_ffmpeg/_io.py#L399.
-	// If bitrate not given, use a default.
+	// If bitrate not given, use the constant quality mode of the encoder.
 	if writer.bitrate == 0 {
-		if writer.codec == "libx264" {
-			// Quality between 0 an 51. 51 is worst.
-			command = append(command, "-crf", fmt.Sprintf("%d", int(writer.quality*51)))
-		} else {
-			// Quality between 1 and 31. 31 is worst.
-			command = append(command, "-qscale:v", fmt.Sprintf("%d", int(writer.quality*30)+1))
-		}
+		command = append(command, qualityArgs(writer.codec, writer.quality)...)
 	} else {
 		command = append(command, "-b:v", fmt.Sprintf("%d", writer.bitrate))
 	}
 
+	rate, params := rateArgs(writer.codec, writer.bitrate, writer.maxrate, writer.bufsize, writer.cbr)
+	command = append(command, rate...)
+
+	if writer.forcekeys != "" || len(writer.keyframes) > 0 {
+		command = append(command, "-force_key_frames", keyframeExpression(writer.forcekeys, writer.keyframes))
+	}
+
+	settings := writer.settings
+	settings.forced = writer.forcekeys != "" || len(writer.keyframes) > 0
+	settings.params = mergeParams(settings.params, params)
+	if pass > 0 {
+		args, params := passArgs(writer.codec, pass, log)
+		command = append(command, args...)
+		settings.params = mergeParams(settings.params, params)
+	}
+
+	encoder, err := encoderArgs(writer.codec, settings)
+	if err != nil {
+		return nil, err
+	}
+	command = append(command, encoder...)
+
+	// Keep the timestamps of the frames instead of dropping or duplicating frames
+	// to get a constant frame rate.
+	if writer.timed {
+		command = append(command, "-vsync", "0")
+	}
+
 	// For GIFs, add looping and delay parameters.
 	if gif {
 		command = append(
@@ -230,51 +808,70 @@ func (writer *VideoWriter) init() error {
 	// Code from the imageio-ffmpeg project:
 	// https://github.com/imageio/imageio-ffmpeg/blob/master/imageio_ffmpeg/_io.py#L415.
 	// Resizes the video frames to a size that works with most codecs.
-	if writer.macro > 1 {
-		if writer.width%writer.macro > 0 || writer.height%writer.macro > 0 {
-			width := writer.width
-			height := writer.height
-			if writer.width%writer.macro > 0 {
-				width += writer.macro - (writer.width % writer.macro)
-			}
-			if writer.height%writer.macro > 0 {
-				height += writer.macro - (writer.height % writer.macro)
-			}
-			writer.width = width
-			writer.height = height
-			command = append(
-				command,
-				"-vf", fmt.Sprintf("scale=%d:%d", width, height),
-			)
-		}
+	filters := []string{}
+	// Repeat the last frame until the audio ends.
+	if extra && writer.length == LengthAudio {
+		filters = append(filters, "tpad=stop_mode=clone:stop=-1")
+	}
+	if width, height := writer.macroSize(); width != writer.width || height != writer.height {
+		filters = append(filters, fmt.Sprintf("scale=%d:%d", width, height))
 	}
 
-	command = append(command, writer.filename)
-	cmd := exec.Command("ffmpeg", command...)
-	writer.cmd = cmd
+	// Render the subtitles at the final frame size.
+	if subtitles != "" && writer.burn {
+		filters = append(filters, "subtitles="+escapeFilterValue(subtitles))
+	}
 
-	pipe, err := cmd.StdinPipe()
-	if err != nil {
-		return err
+	if scale != "" {
+		filters = append(filters, "scale="+scale)
 	}
-	writer.pipe = pipe
 
-	if err := cmd.Start(); err != nil {
-		return err
+	if len(filters) > 0 {
+		command = append(command, "-vf", strings.Join(filters, ","))
 	}
 
-	return nil
+	if writer.timecode != "" {
+		command = append(command, "-timecode", writer.timecode)
+	}
+
+	command = append(command, metadataArgs(writer.metadata, writer.streammeta)...)
+
+	// The first pass only collects statistics.
+	if pass == 1 {
+		return append(command, "-f", "null", "-"), nil
+	}
+
+	return append(command, writer.filename), nil
+}
+
+// Returns the frame size rounded up to a multiple of the macroblock size.
+func (writer *VideoWriter) macroSize() (int, int) {
+	width, height := writer.width, writer.height
+	if writer.macro > 1 {
+		if width%writer.macro > 0 {
+			width += writer.macro - (width % writer.macro)
+		}
+		if height%writer.macro > 0 {
+			height += writer.macro - (height % writer.macro)
+		}
+	}
+	return width, height
 }
 
 // Writes the given frame to the video file.
 func (writer *VideoWriter) Write(frame []byte) error {
-	// If cmd is nil, video writing has not been set up.
-	if writer.cmd == nil {
+	// If pipe is nil, video writing has not been set up.
+	if writer.pipe == nil {
 		if err := writer.init(); err != nil {
 			return err
 		}
 	}
 
+	// Once frames have timestamps, the frame follows the last one at the frame rate.
+	if writer.timed {
+		return writer.WriteAt(frame, writer.pts+time.Duration(float64(time.Second)/writer.fps))
+	}
+
 	total := 0
 	for total < len(frame) {
 		n, err := writer.pipe.Write(frame[total:])
@@ -283,18 +880,166 @@ func (writer *VideoWriter) Write(frame []byte) error {
 		}
 		total += n
 	}
+	writer.frames++
+
+	return nil
+}
+
+// Writes the given frame to the video file with the given presentation timestamp, for frames
+// captured at irregular intervals. Timestamps must increase. The output keeps the timestamps
+// as a variable frame rate video. WriteAt() must be used from the first frame on; frames
+// written with Write() afterwards follow the previous frame at the frame rate.
+func (writer *VideoWriter) WriteAt(frame []byte, pts time.Duration) error {
+	if writer.pipe == nil {
+		if strings.HasSuffix(strings.ToLower(writer.filename), ".avi") {
+			return fmt.Errorf("vidio: avi files do not support variable frame rates")
+		}
+		writer.timed = true
+		if err := writer.init(); err != nil {
+			return err
+		}
+	} else if !writer.timed {
+		return fmt.Errorf("vidio: WriteAt must be used from the first frame")
+	}
+	if writer.mkv == nil {
+		return fmt.Errorf("vidio: timed video writing has not been set up")
+	}
+
+	if pts < 0 {
+		return fmt.Errorf("vidio: timestamp %s must not be negative", pts)
+	}
+	// The Matroska stream stores timestamps in microseconds.
+	if writer.frames > 0 && pts.Microseconds() <= writer.pts.Microseconds() {
+		return fmt.Errorf("vidio: timestamp %s must be after the previous timestamp %s", pts, writer.pts)
+	}
+
+	if err := writer.mkv.writeBlock(pts.Microseconds(), true, frame); err != nil {
+		return err
+	}
+	writer.pts = pts
+	writer.frames++
 
 	return nil
 }
 
-// Closes the pipe and stops the ffmpeg process.
-func (writer *VideoWriter) Close() {
+// Writes the given frame to the video file as a keyframe, e.g. at a scene change. Forced keyframes
+// must be known when encoding starts, so this requires Options.BufferFrames or Options.TwoPass,
+// which buffer all raw frames in a temporary file until Close(). That takes width*height*4 bytes
+// of disk space per frame, e.g. 8 MB per 1080p frame. Keyframes at frames known in advance,
+// e.g. segment boundaries, are forced without buffering by Options.Keyframes.
+func (writer *VideoWriter) WriteKeyframe(frame []byte) error {
+	if writer.forcekeys != "" {
+		return fmt.Errorf("vidio: keyframes are already forced by Options.ForceKeyframes")
+	}
+	if !writer.buffered {
+		return fmt.Errorf("vidio: writing keyframes requires Options.BufferFrames or Options.TwoPass")
+	}
+
+	writer.keyframes = append(writer.keyframes, writer.frames)
+	return writer.Write(frame)
+}
+
+// Writes the given interleaved audio samples to the video file. Requires Options.SampleRate.
+// Samples are in the format given by Options.AudioFormat. Frames and audio must be written
+// interleaved: up to 64 MiB of each are queued, after which writes block until ffmpeg reads
+// them, and ffmpeg only reads one input while it has data from the other.
+func (writer *VideoWriter) WriteAudio(samples []byte) error {
+	if writer.samplerate == 0 {
+		return fmt.Errorf("vidio: audio is disabled, set Options.SampleRate to write audio")
+	}
+	// If pipe is nil, video writing has not been set up.
+	if writer.pipe == nil {
+		if err := writer.init(); err != nil {
+			return err
+		}
+	}
+
+	_, err := writer.audio.Write(samples)
+	return err
+}
+
+// Closes the pipe and waits for the ffmpeg process to finish. With Options.TwoPass or
+// Options.BufferFrames, the video is encoded here. Returns the first error
+// of writing the buffered data, encoding or the ffmpeg process.
+func (writer *VideoWriter) Close() error {
+	errs := []error{}
 	if writer.pipe != nil {
-		writer.pipe.Close()
+		errs = append(errs, writer.pipe.Close())
+	}
+	if writer.audio != nil {
+		errs = append(errs, writer.audio.Close())
+	}
+	if writer.buffered {
+		// Buffered data that could not be written completely is not encoded.
+		if writer.framefile != "" && firstError(errs) == nil {
+			errs = append(errs, writer.encode())
+		}
+	} else if writer.cmd != nil {
+		errs = append(errs, writer.cmd.Wait())
+	}
+	for _, file := range writer.tempfiles {
+		os.Remove(file)
+	}
+	return firstError(errs)
+}
+
+// Returns the first non-nil error of the given errors.
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Encodes the buffered frames and audio, in two passes with Options.TwoPass.
+func (writer *VideoWriter) encode() error {
+	dir, err := os.MkdirTemp("", "vidio-")
+	if err != nil {
+		return fmt.Errorf("vidio: failed to create the pass log directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	log := filepath.Join(dir, "pass")
+
+	passes := []int{0}
+	if writer.twopass {
+		passes = []int{1, 2}
+	}
+
+	for _, pass := range passes {
+		command, err := writer.command(writer.framefile, writer.audiofile, pass, log)
+		if err != nil {
+			return err
+		}
+		writer.cmd = exec.Command("ffmpeg", command...)
+		if err := writer.cmd.Run(); err != nil {
+			if pass == 0 {
+				return fmt.Errorf("vidio: failed to encode %s: %w", writer.filename, err)
+			}
+			return fmt.Errorf("vidio: pass %d of encoding %s failed: %w", pass, writer.filename, err)
+		}
 	}
-	if writer.cmd != nil {
-		writer.cmd.Wait()
+	writer.width, writer.height = writer.macroSize()
+
+	return nil
+}
+
+// Writes the given data to a new temporary file, which is removed by Close().
+func (writer *VideoWriter) tempFile(pattern, data string) (string, error) {
+	file, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", err
 	}
+	writer.tempfiles = append(writer.tempfiles, file.Name())
+
+	_, err = file.WriteString(data)
+	file.Close()
+	if err != nil {
+		return "", err
+	}
+
+	return file.Name(), nil
 }
 
 // Stops the "cmd" process running when the user presses Ctrl+C.
@@ -304,12 +1049,114 @@ func (writer *VideoWriter) cleanup() {
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	go func() {
 		<-c
+		if writer.cmd != nil {
+			writer.cmd.Process.Kill()
+		}
+		// Queued writes fail once ffmpeg is gone, so closing does not block.
 		if writer.pipe != nil {
 			writer.pipe.Close()
 		}
-		if writer.cmd != nil {
-			writer.cmd.Process.Kill()
+		if writer.audio != nil {
+			writer.audio.Close()
+		}
+		for _, file := range writer.tempfiles {
+			os.Remove(file)
 		}
 		os.Exit(1)
 	}()
 }
+
+// Forwards writes to a pipe from a goroutine. Written data is copied into an in-memory
+// queue, so Write() only blocks on the reader of the pipe once "limit" bytes are queued.
+type asyncPipe struct {
+	pipe   io.WriteCloser // Destination pipe.
+	limit  int            // Number of queued bytes above which Write() blocks.
+	mutex  sync.Mutex     // Guards the fields below.
+	cond   *sync.Cond     // Signals new data, written data or closing.
+	queue  [][]byte       // Data waiting to be written.
+	size   int            // Number of bytes queued or being written.
+	closed bool           // True once Close() has been called.
+	err    error          // First error returned by the pipe.
+	done   chan struct{}  // Closed once the goroutine has finished.
+}
+
+// Number of bytes queued per pipe before writes block until ffmpeg has read some of them.
+const asyncPipeLimit = 64 << 20
+
+func newAsyncPipe(pipe io.WriteCloser, limit int) *asyncPipe {
+	async := &asyncPipe{pipe: pipe, limit: limit, done: make(chan struct{})}
+	async.cond = sync.NewCond(&async.mutex)
+	go async.run()
+	return async
+}
+
+// Writes the queued data to the pipe until the queue is closed and empty.
+func (async *asyncPipe) run() {
+	defer close(async.done)
+	for {
+		async.mutex.Lock()
+		for len(async.queue) == 0 && !async.closed {
+			async.cond.Wait()
+		}
+		if len(async.queue) == 0 {
+			async.mutex.Unlock()
+			break
+		}
+		data := async.queue[0]
+		async.queue[0] = nil
+		async.queue = async.queue[1:]
+		failed := async.err != nil
+		async.mutex.Unlock()
+
+		// After an error the remaining data is discarded.
+		var err error
+		if !failed {
+			_, err = async.pipe.Write(data)
+		}
+
+		async.mutex.Lock()
+		if err != nil && async.err == nil {
+			async.err = err
+		}
+		async.size -= len(data)
+		// Wake up writers waiting for space in the queue.
+		async.cond.Broadcast()
+		async.mutex.Unlock()
+	}
+	err := async.pipe.Close()
+	async.mutex.Lock()
+	if async.err == nil {
+		async.err = err
+	}
+	async.mutex.Unlock()
+}
+
+// Queues a copy of the given data, waiting while the queue is full. Data larger than the limit
+// is queued once the queue is empty. Returns the first error of an earlier write, if any.
+func (async *asyncPipe) Write(data []byte) (int, error) {
+	async.mutex.Lock()
+	defer async.mutex.Unlock()
+	for async.size > 0 && async.size+len(data) > async.limit && async.err == nil && !async.closed {
+		async.cond.Wait()
+	}
+	if async.err != nil {
+		return 0, async.err
+	}
+	if async.closed {
+		return 0, fmt.Errorf("vidio: write to closed pipe")
+	}
+	async.queue = append(async.queue, append([]byte(nil), data...))
+	async.size += len(data)
+	async.cond.Broadcast()
+	return len(data), nil
+}
+
+// Writes all queued data, closes the pipe and returns the first error.
+func (async *asyncPipe) Close() error {
+	async.mutex.Lock()
+	async.closed = true
+	async.cond.Broadcast()
+	async.mutex.Unlock()
+	<-async.done
+	return async.err
+}
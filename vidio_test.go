@@ -1,10 +1,15 @@
 package vidio
 
 import (
+	"bytes"
+	"encoding/hex"
 	"image"
 	"image/png"
+	"io"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func assertEquals(t *testing.T, actual, expected interface{}) {
@@ -28,6 +33,18 @@ func TestSetBuffer(t *testing.T) {
 	assertEquals(t, len(video.framebuffer), size)
 }
 
+func TestSetBitDepth(t *testing.T) {
+	video := &Video{width: 4, height: 2, depth: 4, bitdepth: 8}
+
+	video.SetBitDepth(16)
+	video.framebuffer = make([]byte, video.frameSize())
+	assertEquals(t, len(video.framebuffer), 64)
+
+	video.SetBitDepth(8)
+	assertEquals(t, video.framebuffer == nil, true)
+	assertEquals(t, video.SetBitDepth(12) != nil, true)
+}
+
 func TestVideoMetaData(t *testing.T) {
 	video, err := NewVideo("test/koala.mp4")
 	if err != nil {
@@ -39,6 +56,7 @@ func TestVideoMetaData(t *testing.T) {
 	assertEquals(t, video.width, 480)
 	assertEquals(t, video.height, 270)
 	assertEquals(t, video.depth, 4)
+	assertEquals(t, video.bitdepth, 8)
 	assertEquals(t, video.bitrate, 170549)
 	assertEquals(t, video.frames, 101)
 	assertEquals(t, video.duration, 3.366667)
@@ -46,6 +64,8 @@ func TestVideoMetaData(t *testing.T) {
 	assertEquals(t, video.codec, "h264")
 	assertEquals(t, video.stream, 0)
 	assertEquals(t, video.hasstreams, true)
+	assertEquals(t, len(video.audio), 1)
+	assertEquals(t, video.audio[0].Codec, "aac")
 	assertEquals(t, len(video.framebuffer), 0)
 
 	if video.pipe != nil {
@@ -116,6 +136,43 @@ func TestVideoWriting(t *testing.T) {
 	testWriting("test/koala-noaudio.mp4", "test/koala-noaudio-out.mp4")
 }
 
+func TestAudioWriting(t *testing.T) {
+	video, err := NewVideo("test/koala.mp4")
+	if err != nil {
+		t.Fatalf("Failed to create the video: %s", err)
+	}
+	audio, err := NewSyncedAudio(video, &AudioOptions{SampleRate: 48000, Channels: 1})
+	if err != nil {
+		t.Fatalf("Failed to create the audio: %s", err)
+	}
+
+	output := "test/koala-audio-out.mp4"
+	writer, err := NewVideoWriter(output, video.width, video.height, &Options{FPS: video.fps, SampleRate: 48000, Channels: 1})
+	if err != nil {
+		t.Fatalf("Failed to create the video writer: %s", err)
+	}
+	defer os.Remove(output)
+
+	for video.Read() && audio.Read() {
+		if err := writer.Write(video.FrameBuffer()); err != nil {
+			t.Fatalf("Failed to write frame: %s", err)
+		}
+		if err := writer.WriteAudio(audio.Buffer()); err != nil {
+			t.Fatalf("Failed to write audio: %s", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close the video writer: %s", err)
+	}
+
+	copied, err := NewVideo(output)
+	if err != nil {
+		t.Fatalf("Failed to open the written video: %s", err)
+	}
+	assertEquals(t, len(copied.audio), 1)
+	assertEquals(t, copied.frames, video.frames)
+}
+
 func TestCameraIO(t *testing.T) {
 	webcam, err := NewCamera(0)
 	if err != nil {
@@ -221,6 +278,960 @@ At least one output file must be specified`,
 	assertEquals(t, camera.codec, "mjpeg")
 }
 
+func TestHDRMetadataParsing(t *testing.T) {
+	hdr := parseHDRMetadata([]map[string]interface{}{
+		{"side_data_type": "Display Matrix"},
+		{
+			"side_data_type": "Mastering display metadata",
+			"red_x":          "34000/50000",
+			"red_y":          "16000/50000",
+			"green_x":        "13250/50000",
+			"green_y":        "34500/50000",
+			"blue_x":         "7500/50000",
+			"blue_y":         "3000/50000",
+			"white_point_x":  "15635/50000",
+			"white_point_y":  "16450/50000",
+			"min_luminance":  "50/10000",
+			"max_luminance":  "10000000/10000",
+		},
+		{"side_data_type": "Content light level metadata", "max_content": float64(1000), "max_average": float64(400)},
+	})
+
+	assertEquals(t, hdr.RedX, 0.68)
+	assertEquals(t, hdr.WhiteY, 0.329)
+	assertEquals(t, hdr.MinLuminance, 0.005)
+	assertEquals(t, hdr.MaxLuminance, float64(1000))
+	assertEquals(t, hdr.MaxCLL, 1000)
+	assertEquals(t, hdr.MaxFALL, 400)
+
+	if parseHDRMetadata(nil) != nil {
+		t.Errorf("Expected nil HDR metadata for SDR video")
+	}
+
+	color := parseColor(map[string]string{"color_transfer": "smpte2084", "color_space": "unknown"})
+	assertEquals(t, color.HDR(), true)
+	assertEquals(t, color.Space, "")
+}
+
+func TestColorDetection(t *testing.T) {
+	sd := detectColor(Color{}, 480, 270, "yuv420p")
+	assertEquals(t, sd, Color{Space: "smpte170m", Primaries: "smpte170m", Transfer: "smpte170m", Range: "tv"})
+	assertEquals(t, rgbFilter(sd), "scale=in_color_matrix=smpte170m:in_range=tv:out_range=pc")
+
+	hd := detectColor(Color{Range: "pc"}, 1920, 1080, "yuv420p")
+	assertEquals(t, hd, Color{Space: "bt709", Primaries: "bt709", Transfer: "bt709", Range: "pc"})
+	assertEquals(t, detectColor(Color{}, 720, 576, "yuvj420p").Range, "pc")
+	assertEquals(t, colorspaceFilter(hd, Color{Primaries: "bt709"}), "")
+	assertEquals(
+		t,
+		colorspaceFilter(sd, Color{Primaries: "bt709", Transfer: "bt709"}),
+		"colorspace=ispace=smpte170m:iprimaries=smpte170m:itrc=smpte170m:irange=tv:space=smpte170m:primaries=bt709:trc=bt709:range=tv",
+	)
+
+	scale, tags := writerColor(Color{Primaries: "bt2020", Transfer: "smpte2084"})
+	assertEquals(t, scale, "in_range=pc:out_color_matrix=bt2020:out_range=tv")
+	assertEquals(t, strings.Join(tags, " "), "-colorspace bt2020nc -color_range tv -color_primaries bt2020 -color_trc smpte2084")
+
+	scale, tags = writerColor(Color{})
+	assertEquals(t, scale, "")
+	assertEquals(t, len(tags), 0)
+}
+
+func TestIdetParsing(t *testing.T) {
+	interlaced := parseIdet(
+		`[Parsed_idet_0 @ 0x7f8b3c] Repeated Fields: Neither:   250 Top:     0 Bottom:     0
+[Parsed_idet_0 @ 0x7f8b3c] Single frame detection: TFF:   180 BFF:     0 Progressive:    40 Undetermined:    30
+[Parsed_idet_0 @ 0x7f8b3c] Multi frame detection: TFF:   238 BFF:     0 Progressive:     5 Undetermined:     7`,
+	)
+
+	assertEquals(t, interlaced.TFF, 238)
+	assertEquals(t, interlaced.Progressive, 5)
+	assertEquals(t, interlaced.Undetermined, 7)
+	assertEquals(t, interlaced.RepeatedNeither, 250)
+	assertEquals(t, interlaced.Interlaced, true)
+	assertEquals(t, interlaced.Telecined, false)
+
+	telecined := parseIdet(
+		`[Parsed_idet_0 @ 0x7f8b3c] Repeated Fields: Neither:   150 Top:    50 Bottom:    50
+[Parsed_idet_0 @ 0x7f8b3c] Multi frame detection: TFF:   100 BFF:     0 Progressive:   150 Undetermined:     0`,
+	)
+
+	assertEquals(t, telecined.RepeatedTop, 50)
+	assertEquals(t, telecined.Interlaced, false)
+	assertEquals(t, telecined.Telecined, true)
+}
+
+func TestKeyframeIndex(t *testing.T) {
+	// Decode order of an open GOP stream: I0 P3 B1 B2 | I6 B4 B5 P8 B7.
+	packets := []packetInfo{}
+	for _, line := range []string{
+		"packet|pts_time=0.000000|dts_time=-0.100000|flags=K__",
+		"packet|pts_time=0.300000|dts_time=0.000000|flags=___",
+		"packet|pts_time=0.100000|dts_time=0.100000|flags=___",
+		"packet|pts_time=0.200000|dts_time=0.200000|flags=___",
+		"packet|pts_time=0.600000|dts_time=0.300000|flags=K__",
+		"packet|pts_time=0.400000|dts_time=0.400000|flags=___",
+		"packet|pts_time=0.500000|dts_time=0.500000|flags=___",
+		"packet|pts_time=0.800000|dts_time=0.600000|flags=___",
+		"packet|pts_time=N/A|dts_time=0.700000|flags=___",
+	} {
+		packets = append(packets, parsePacketInfo(parseCompact(line)))
+	}
+
+	keyframes, gop := indexPackets(packets)
+
+	assertEquals(t, len(keyframes), 2)
+	assertEquals(t, keyframes[0], Keyframe{Frame: 0, Timestamp: 0})
+	assertEquals(t, keyframes[1], Keyframe{Frame: 6, Timestamp: 0.6})
+	assertEquals(t, gop.Count, 2)
+	assertEquals(t, gop.Min, 3)
+	assertEquals(t, gop.Max, 6)
+	assertEquals(t, gop.Average, 4.5)
+	assertEquals(t, gop.OpenGOPs, 1)
+	assertEquals(t, gop.ClosedGOPs, 1)
+}
+
+func TestFrameStatParsing(t *testing.T) {
+	stat := parseFrameStat(
+		parseCompact("frame|key_frame=0|pts_time=0.133333|pkt_dts_time=N/A|duration_time=0.033333|pkt_pos=4863|pkt_size=512|pict_type=B"),
+		parseCompact("packet|dts_time=0.066667|pos=4863|flags=__C"),
+	)
+
+	assertEquals(t, stat.PictType, "B")
+	assertEquals(t, stat.Keyframe, false)
+	assertEquals(t, stat.Size, 512)
+	assertEquals(t, stat.PTS, 0.133333)
+	assertEquals(t, stat.DTS, 0.066667)
+	assertEquals(t, stat.Duration, 0.033333)
+	assertEquals(t, stat.Corrupt, true)
+}
+
+func TestBitrateSeries(t *testing.T) {
+	stats := []FrameStat{}
+	for i := 0; i < 30; i++ {
+		stats = append(stats, FrameStat{Frame: i, Size: 1000, PTS: float64(i) * 0.1, Duration: 0.1})
+	}
+
+	samples := BitrateSeries(stats, 1)
+
+	assertEquals(t, len(samples), 3)
+	assertEquals(t, samples[0].Start, float64(0))
+	assertEquals(t, samples[1].Start, float64(1))
+	assertEquals(t, int(samples[0].Bitrate), 80000)
+	assertEquals(t, int(samples[2].Bitrate+0.5), 80000)
+	assertEquals(t, len(BitrateSeries(nil, 1)), 0)
+}
+
+func TestExtradataParsing(t *testing.T) {
+	extradata, err := parseHexDump(`
+00000000: 0164 001e ffe1 0008 6764 001e acd9 4078  .d......gd....@x
+00000010: 0100 0468 ebe3 cb                        ...h...
+`)
+	if err != nil {
+		t.Errorf("Failed to parse hex dump: %s", err)
+	}
+	assertEquals(t, len(extradata), 23)
+
+	sets, nalsize := parseExtradata("h264", extradata)
+	assertEquals(t, nalsize, 4)
+	assertEquals(t, len(sets), 2)
+	assertEquals(t, hex.EncodeToString(sets[0]), "6764001eacd94078")
+	assertEquals(t, hex.EncodeToString(sets[1]), "68ebe3cb")
+
+	sets, nalsize = parseExtradata("h264", []byte{0, 0, 0, 1, 0x67, 0x64, 0, 0, 1, 0x68, 0xeb})
+	assertEquals(t, nalsize, 0)
+	assertEquals(t, len(sets), 2)
+	assertEquals(t, hex.EncodeToString(sets[1]), "68eb")
+}
+
+func TestPacketConversion(t *testing.T) {
+	reader := &PacketReader{
+		codec:         "h264",
+		format:        AnnexB,
+		nalsize:       4,
+		parametersets: [][]byte{{0x67, 0x64}, {0x68, 0xeb}},
+	}
+	avcc := []byte{0, 0, 0, 2, 0x65, 0x88, 0, 0, 0, 1, 0x06}
+
+	annexb := reader.convert(avcc, true)
+	assertEquals(t, hex.EncodeToString(annexb), "0000000167640000000168eb0000000165880000000106")
+	assertEquals(t, hex.EncodeToString(reader.convert(avcc, false)), "0000000165880000000106")
+
+	reader.format, reader.nalsize = LengthPrefixed, 0
+	assertEquals(t, hex.EncodeToString(reader.convert([]byte{0, 0, 1, 0x65, 0x88, 0, 0, 0, 1, 0x06}, false)), hex.EncodeToString(avcc))
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	video, err := NewVideo("test/koala.mp4")
+	if err != nil {
+		t.Fatalf("Failed to create the video: %s", err)
+	}
+
+	reader, err := NewPacketReader(video, LengthPrefixed)
+	if err != nil {
+		t.Fatalf("Failed to create the packet reader: %s", err)
+	}
+
+	output := "test/koala-packets.mp4"
+	writer, err := NewPacketWriter(output, video.width, video.height, reader.Extradata(), &Options{Codec: video.codec})
+	if err != nil {
+		t.Fatalf("Failed to create the packet writer: %s", err)
+	}
+	defer os.Remove(output)
+
+	packets := 0
+	for reader.Read() {
+		if err := writer.Write(reader.Packet()); err != nil {
+			t.Fatalf("Failed to write packet %d: %s", packets, err)
+		}
+		packets++
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Failed to close the packet writer: %s", err)
+	}
+
+	assertEquals(t, packets, video.frames)
+
+	copied, err := NewVideo(output)
+	if err != nil {
+		t.Fatalf("Failed to open the copied video: %s", err)
+	}
+	assertEquals(t, copied.frames, video.frames)
+	assertEquals(t, copied.codec, video.codec)
+}
+
+func TestMatroskaWriting(t *testing.T) {
+	assertEquals(t, hex.EncodeToString(ebmlSize(1)), "81")
+	assertEquals(t, hex.EncodeToString(ebmlSize(127)), "407f")
+	assertEquals(t, hex.EncodeToString(ebmlSize(300)), "412c")
+	assertEquals(t, hex.EncodeToString(ebmlUint(mkvTimestampScale, 1000)), "2ad7b18203e8")
+
+	buffer := bytes.Buffer{}
+	mkv, err := newMatroska(&buffer, "h264", 480, 270, nil, nil)
+	if err != nil {
+		t.Errorf("Failed to write matroska header: %s", err)
+	}
+	if !bytes.HasPrefix(buffer.Bytes(), []byte{0x1A, 0x45, 0xDF, 0xA3}) {
+		t.Errorf("Expected matroska stream to start with the EBML header")
+	}
+
+	buffer.Reset()
+	mkv.writeBlock(1000, true, []byte{0xAA})
+	mkv.writeBlock(30000, false, []byte{0xBB})
+	mkv.writeBlock(40000, false, []byte{0xCC})
+
+	assertEquals(
+		t,
+		hex.EncodeToString(buffer.Bytes()),
+		"1f43b67501ffffffffffffff"+"e78203e8"+"a385"+"81000080aa"+ // Cluster at 1000us, keyframe.
+			"a385"+"81714800bb"+ // Relative timestamp 29000us.
+			"1f43b67501ffffffffffffff"+"e7829c40"+"a385"+"81000000cc", // New cluster, 39000us does not fit in an int16.
+	)
+
+	if _, err := newMatroska(&buffer, "unknown", 1, 1, nil, nil); err == nil {
+		t.Errorf("Expected unsupported codec error")
+	}
+}
+
+func TestKeyframeSnapping(t *testing.T) {
+	keyframes := []Keyframe{{0, 0}, {60, 2.002}, {120, 4.004}}
+
+	assertEquals(t, previousKeyframe(keyframes, 3), 2.002)
+	assertEquals(t, previousKeyframe(keyframes, 4.004), 4.004)
+	assertEquals(t, nextKeyframe(keyframes, 0.5), 2.002)
+	assertEquals(t, nextKeyframe(keyframes, 5), float64(-1))
+	assertEquals(t, encoderFor("hevc"), "libx265")
+	assertEquals(t, encoderFor("unknown"), "libx264")
+}
+
+func TestSmartTrim(t *testing.T) {
+	testTrim := func(input, output string, audio int) {
+		defer os.Remove(output)
+		if err := Trim(input, output, 0.5, 2.5, TrimSmart); err != nil {
+			t.Fatalf("Failed to trim %s: %s", input, err)
+		}
+
+		video, err := NewVideo(output)
+		if err != nil {
+			t.Fatalf("Failed to open the trimmed video: %s", err)
+		}
+		// 2 seconds at 30 fps, allowing for one frame of rounding at the edges.
+		if video.frames < 59 || video.frames > 61 {
+			t.Errorf("Expected about 60 frames, got %d", video.frames)
+		}
+		assertEquals(t, video.codec, "h264")
+		assertEquals(t, len(video.audio), audio)
+	}
+
+	testTrim("test/koala.mp4", "test/koala-trim.mp4", 1)
+	testTrim("test/koala-noaudio.mp4", "test/koala-noaudio-trim.mp4", 0)
+}
+
+func TestSourceSettings(t *testing.T) {
+	settings := sourceSettings("h264", "Constrained Baseline", "31")
+	assertEquals(t, settings.profile, "baseline")
+	assertEquals(t, settings.level, "3.1")
+
+	settings = sourceSettings("h264", "High 4:4:4 Predictive", "51")
+	assertEquals(t, settings.profile, "high444")
+
+	settings = sourceSettings("hevc", "Main 10", "120")
+	assertEquals(t, settings.profile, "main10")
+	assertEquals(t, settings.level, "4.0")
+
+	settings = sourceSettings("hevc", "Rext", "-99")
+	assertEquals(t, settings.profile, "")
+	assertEquals(t, settings.level, "")
+
+	settings = sourceSettings("vp9", "Profile 0", "-99")
+	assertEquals(t, settings.profile, "")
+
+	args, _ := encoderArgs("libx265", sourceSettings("hevc", "Main", "93"))
+	assertEquals(t, strings.Join(args, " "), "-profile:v main -x265-params level-idc=3.1")
+}
+
+func TestConcatArgs(t *testing.T) {
+	a := concatInput{
+		video: &Video{filename: "a.mp4", width: 1920, height: 1080, fps: 30, codec: "h264", pixfmt: "yuv420p", duration: 2},
+		audio: []map[string]string{{"codec_name": "aac", "sample_rate": "48000", "channels": "2"}},
+	}
+	b := concatInput{
+		video: &Video{filename: "b.mp4", width: 1280, height: 720, fps: 25, codec: "h264", pixfmt: "yuv420p", duration: 3},
+	}
+
+	assertEquals(t, concatCompatible([]concatInput{a, a}), true)
+	assertEquals(t, concatCompatible([]concatInput{a, b}), false)
+
+	// Same frame parameters, but a Baseline and a High profile H.264 stream.
+	a.stream = map[string]string{"profile": "Constrained Baseline", "level": "31", "time_base": "1/30000"}
+	c := a
+	c.stream = map[string]string{"profile": "High", "level": "40", "time_base": "1/30000"}
+	assertEquals(t, concatCompatible([]concatInput{a, c}), false)
+	c.stream = map[string]string{"profile": "Constrained Baseline", "level": "31", "time_base": "1/90000"}
+	assertEquals(t, concatCompatible([]concatInput{a, c}), false)
+	c.stream = a.stream
+	c.audio = []map[string]string{{"codec_name": "aac", "sample_rate": "48000", "channels": "2", "sample_fmt": "s16p"}}
+	assertEquals(t, concatCompatible([]concatInput{a, c}), false)
+
+	args := concatReencodeArgs([]concatInput{a, b}, "out.mp4", &ConcatOptions{})
+	assertEquals(
+		t,
+		strings.Join(args, " "),
+		"-i a.mp4 -i b.mp4 -f lavfi -t 3.000000 -i anullsrc=r=48000:cl=stereo -filter_complex "+
+			"[0:v:0]scale=1920:1080:force_original_aspect_ratio=decrease,pad=1920:1080:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=30,format=yuv420p[v0];"+
+			"[0:a:0]aresample=48000,aformat=sample_fmts=fltp:channel_layouts=stereo[a0];"+
+			"[1:v:0]scale=1920:1080:force_original_aspect_ratio=decrease,pad=1920:1080:(ow-iw)/2:(oh-ih)/2,setsar=1,fps=30,format=yuv420p[v1];"+
+			"[2:a]aresample=48000,aformat=sample_fmts=fltp:channel_layouts=stereo[a1];"+
+			"[v0][a0][v1][a1]concat=n=2:v=1:a=1[v][a] -map [v] -c:v libx264 -map [a] -c:a aac out.mp4",
+	)
+}
+
+func TestRemuxArgs(t *testing.T) {
+	streams := map[string][]map[string]string{
+		"v": {{"index": "0"}},
+		"a": {{"index": "1", "tag:language": "eng"}, {"index": "2", "tag:language": "eng"}, {"index": "3", "tag:language": "ger"}},
+		"s": {{"index": "4", "tag:language": "eng"}, {"index": "5", "tag:language": "ger"}},
+		"d": {},
+		"t": {},
+	}
+
+	args, _, err := selectionArgs(streams, []StreamSelection{
+		{Type: "v", Index: 0},
+		{Type: "a", Index: -1, Language: "ger", Codec: "aac", Default: true},
+		{Type: "a", Index: 0, Language: "eng"},
+		{Type: "s", Index: -1, Forced: true},
+	}, 0, 0, "")
+	if err != nil {
+		t.Errorf("Failed to build remux arguments: %s", err)
+	}
+
+	assertEquals(
+		t,
+		strings.Join(args, " "),
+		"-map 0:0 -c:0 copy -map 0:3 -c:1 aac -disposition:1 default -map 0:1 -c:2 copy -disposition:2 0 "+
+			"-map 0:4 -c:3 copy -disposition:3 forced -map 0:5 -c:4 copy -disposition:4 forced",
+	)
+
+	if _, _, err := selectionArgs(streams, []StreamSelection{{Type: "a", Index: 1, Language: "ger"}}, 0, 0, ""); err == nil {
+		t.Errorf("Expected error for missing stream")
+	}
+}
+
+func TestStreamParsing(t *testing.T) {
+	audio := parseAudioStream(1, parseCompact(
+		"stream|index=2|codec_name=opus|sample_rate=48000|channels=6|channel_layout=5.1|bit_rate=N/A|duration=12.5|"+
+			"disposition:default=0|disposition:comment=1|tag:language=eng|tag:title=Director's Commentary",
+	))
+
+	assertEquals(t, audio.Index, 2)
+	assertEquals(t, audio.Stream, 1)
+	assertEquals(t, audio.Codec, "opus")
+	assertEquals(t, audio.SampleRate, 48000)
+	assertEquals(t, audio.Channels, 6)
+	assertEquals(t, audio.Layout, "5.1")
+	assertEquals(t, audio.Bitrate, 0)
+	assertEquals(t, audio.Duration, 12.5)
+	assertEquals(t, audio.Language, "eng")
+	assertEquals(t, audio.Title, "Director's Commentary")
+	assertEquals(t, audio.Disposition, Disposition{Comment: true})
+
+	subtitle := parseSubtitleStream(0, parseCompact("stream|index=3|codec_name=subrip|disposition:forced=1|tag:language=ger"))
+	assertEquals(t, subtitle.Codec, "subrip")
+	assertEquals(t, subtitle.Disposition.Forced, true)
+
+	attachment := parseAttachmentStream(0, parseCompact("stream|index=4|codec_name=ttf|tag:filename=font.ttf|tag:mimetype=font/ttf"))
+	assertEquals(t, attachment.Filename, "font.ttf")
+	assertEquals(t, attachment.MimeType, "font/ttf")
+}
+
+func TestAudioFrameSamples(t *testing.T) {
+	audio := &Audio{samplerate: 48000, channels: 2, format: "s16le", fps: 30000.0 / 1001.0, start: 0.5, duration: 10}
+
+	total := 0
+	for i := 0; i < 30000; i++ {
+		samples := audio.frameSamples(i)
+		if samples != 1601 && samples != 1602 {
+			t.Errorf("Frame %d has %d samples.", i, samples)
+		}
+		total += samples
+	}
+	// 30000 frames at 29.97 fps last exactly 1001 seconds.
+	assertEquals(t, total, 48000*1001)
+
+	command := strings.Join(audio.command(), " ")
+	if !strings.Contains(command, "-af aresample=async=1:first_pts=0,atrim=start=0.500000,asetpts=PTS-STARTPTS,apad=whole_dur=10.000000 -t 10.000000 -") {
+		t.Errorf("Unexpected audio filters: %s", command)
+	}
+
+	// Without a known duration the audio is not padded, so reading it ends.
+	audio.duration = 0
+	command = strings.Join(audio.command(), " ")
+	if strings.Contains(command, "apad") || strings.Contains(command, "-t ") {
+		t.Errorf("Unexpected unlimited padding: %s", command)
+	}
+}
+
+func TestSyncedAudio(t *testing.T) {
+	// MPEG-TS files start at 1.4 seconds, which ffmpeg removes from the input timestamps.
+	input := "test/koala-synced.ts"
+	if err := ffmpeg("-i", "test/koala.mp4", "-c", "copy", input); err != nil {
+		t.Fatalf("Failed to remux the video: %s", err)
+	}
+	defer os.Remove(input)
+
+	video, err := NewVideo(input)
+	if err != nil {
+		t.Fatalf("Failed to create the video: %s", err)
+	}
+	audio, err := NewSyncedAudio(video, nil)
+	if err != nil {
+		t.Fatalf("Failed to create the audio: %s", err)
+	}
+	if audio.start > 0.1 {
+		t.Errorf("Expected the video to start near the file start, got %f", audio.start)
+	}
+
+	frames := 0
+	for video.Read() && audio.Read() {
+		assertEquals(t, audio.Samples(), audio.frameSamples(frames))
+		frames++
+	}
+	// Every frame of koala.mp4 has its audio.
+	assertEquals(t, frames, 101)
+}
+
+func TestBufferedWritingError(t *testing.T) {
+	writer := &VideoWriter{
+		filename: os.TempDir() + "/vidio-missing.mp4",
+		width:    2,
+		height:   2,
+		fps:      25,
+		bitrate:  100000,
+		codec:    "vidio-missing-encoder",
+		twopass:  true,
+		buffered: true,
+	}
+	if err := writer.Write(make([]byte, 2*2*4)); err != nil {
+		t.Fatalf("Failed to buffer frame: %s", err)
+	}
+	frames := writer.framefile
+
+	// Encoding with an unknown encoder fails, which Close() reports.
+	if err := writer.Close(); err == nil {
+		t.Errorf("Expected error from failed encoding")
+	}
+	if _, err := os.Stat(frames); !os.IsNotExist(err) {
+		t.Errorf("Expected buffered frames to be removed")
+	}
+}
+
+func TestTimedWriting(t *testing.T) {
+	reader, pipe := io.Pipe()
+	writer := &VideoWriter{width: 2, height: 1, fps: 25, timed: true, pipe: newAsyncPipe(pipe, asyncPipeLimit)}
+	mkv, err := writer.timedStream(writer.pipe)
+	if err != nil {
+		t.Fatalf("Failed to start matroska stream: %s", err)
+	}
+	writer.mkv = mkv
+
+	frame := make([]byte, 2*1*4)
+	if err := writer.WriteAt(frame, 0); err != nil {
+		t.Errorf("Failed to write frame: %s", err)
+	}
+	if err := writer.WriteAt(frame, 13*time.Millisecond); err != nil {
+		t.Errorf("Failed to write frame: %s", err)
+	}
+	if err := writer.WriteAt(frame, 13*time.Millisecond); err == nil {
+		t.Errorf("Expected error for non-increasing timestamp")
+	}
+	// Write() continues at the frame rate.
+	if err := writer.Write(frame); err != nil {
+		t.Errorf("Failed to write frame: %s", err)
+	}
+	assertEquals(t, writer.pts, 53*time.Millisecond)
+	assertEquals(t, writer.frames, 3)
+
+	result := make(chan []byte)
+	go func() {
+		all, _ := io.ReadAll(reader)
+		result <- all
+	}()
+	writer.pipe.Close()
+
+	all := <-result
+	if !bytes.HasPrefix(all, []byte{0x1A, 0x45, 0xDF, 0xA3}) {
+		t.Errorf("Expected matroska stream to start with the EBML header")
+	}
+	if !bytes.Contains(all, []byte("V_UNCOMPRESSED")) || !bytes.Contains(all, []byte("RGBA")) {
+		t.Errorf("Expected raw RGBA video track")
+	}
+
+	untimed := &VideoWriter{pipe: newAsyncPipe(pipe, asyncPipeLimit)}
+	if err := untimed.WriteAt(frame, 0); err == nil {
+		t.Errorf("Expected error for WriteAt after Write")
+	}
+	untimed.pipe.Close()
+
+	// A writer whose matroska stream failed to start returns an error instead of panicking.
+	broken := &VideoWriter{timed: true, pipe: newAsyncPipe(pipe, asyncPipeLimit)}
+	if err := broken.WriteAt(frame, 0); err == nil {
+		t.Errorf("Expected error for missing matroska stream")
+	}
+	if err := broken.Write(frame); err == nil {
+		t.Errorf("Expected error for missing matroska stream")
+	}
+	broken.pipe.Close()
+}
+
+func TestAsyncPipe(t *testing.T) {
+	reader, writer := io.Pipe()
+	pipe := newAsyncPipe(writer, asyncPipeLimit)
+
+	// Writes must not block even though nothing is reading yet.
+	data := []byte{1, 2, 3}
+	for i := 0; i < 100; i++ {
+		if _, err := pipe.Write(data); err != nil {
+			t.Fatalf("Failed to write: %s", err)
+		}
+	}
+	// The data is copied, so changing it afterwards has no effect.
+	data[0] = 0
+
+	result := make(chan []byte)
+	go func() {
+		all, _ := io.ReadAll(reader)
+		result <- all
+	}()
+
+	if err := pipe.Close(); err != nil {
+		t.Errorf("Failed to close: %s", err)
+	}
+
+	all := <-result
+	assertEquals(t, len(all), 300)
+	assertEquals(t, bytes.Equal(all[297:], []byte{1, 2, 3}), true)
+
+	if _, err := pipe.Write(data); err == nil {
+		t.Errorf("Expected error when writing to a closed pipe")
+	}
+
+	// Writes block once the queue is full, until the reader catches up.
+	reader, writer = io.Pipe()
+	pipe = newAsyncPipe(writer, 4)
+	pipe.Write([]byte{1, 2, 3})
+
+	written := make(chan struct{})
+	go func() {
+		pipe.Write([]byte{4, 5, 6})
+		close(written)
+	}()
+	select {
+	case <-written:
+		t.Errorf("Expected write to block while the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	first := make([]byte, 3)
+	io.ReadFull(reader, first)
+	<-written
+	assertEquals(t, bytes.Equal(first, []byte{1, 2, 3}), true)
+
+	go io.Copy(io.Discard, reader)
+	pipe.Close()
+}
+
+func TestStreamFileArgs(t *testing.T) {
+	file := StreamFile{Filename: "music.mp3", Offset: 12.5, Duration: 30}
+	assertEquals(t, strings.Join(streamFileInput(file), " "), "-ss 12.500000 -t 30.000000 -i music.mp3")
+	assertEquals(t, strings.Join(streamFileInput(StreamFile{Filename: "music.mp3"}), " "), "-i music.mp3")
+
+	streams := map[string][]map[string]string{
+		"v": {{"index": "0"}},
+		"a": {{"index": "1"}, {"index": "2"}},
+		"s": {{"index": "3"}},
+		"d": {},
+		"t": {},
+	}
+	args, next, err := selectionArgs(streams, defaultStreams, 2, 2, "aac")
+	if err != nil {
+		t.Errorf("Failed to build stream file arguments: %s", err)
+	}
+	assertEquals(t, next, 5)
+	assertEquals(t, strings.Join(args, " "), "-map 2:1 -c:2 aac -map 2:2 -c:3 aac -map 2:3 -c:4 copy")
+
+	length, _ := lengthPolicy("", nil)
+	assertEquals(t, length, LengthLongest)
+	length, _ = lengthPolicy("", []StreamFile{file})
+	assertEquals(t, length, LengthShortest)
+	if _, err := lengthPolicy("subtitles", nil); err == nil {
+		t.Errorf("Expected error for unsupported length policy")
+	}
+
+	assertEquals(t, strings.Join(lengthArgs(LengthVideo), " "), "-filter:a apad -shortest")
+	assertEquals(t, len(lengthArgs(LengthLongest)), 0)
+}
+
+func TestSRTParsing(t *testing.T) {
+	data := "\ufeff1\r\n00:00:01,000 --> 00:00:02,500\r\nHello\r\n<i>World</i>\r\n\r\n" +
+		"2\n00:01:02,250 --> 01:00:00,000 X1:10 X2:20\nSecond\n\n\n"
+
+	cues, err := parseSRT(data)
+	if err != nil {
+		t.Fatalf("Failed to parse SRT: %s", err)
+	}
+
+	assertEquals(t, len(cues), 2)
+	assertEquals(t, cues[0], Cue{Index: 1, Start: 1, End: 2.5, Text: "Hello\n<i>World</i>"})
+	assertEquals(t, cues[1], Cue{Index: 2, Start: 62.25, End: 3600, Text: "Second"})
+
+	if _, err := parseSRT("1\n00:00:01 -> 00:00:02\nBroken"); err == nil {
+		t.Errorf("Expected error for invalid timing line")
+	}
+}
+
+func TestSubtitleWriting(t *testing.T) {
+	cues := []Cue{
+		{Start: 0.5, End: 2, Text: "Hello"},
+		{Start: 3661.0015, End: 3662.25, Text: "Two\nLines"},
+	}
+
+	data := formatSRT(cues)
+	assertEquals(t, data, "1\n00:00:00,500 --> 00:00:02,000\nHello\n\n2\n01:01:01,002 --> 01:01:02,250\nTwo\nLines\n\n")
+
+	parsed, err := parseSRT(data)
+	if err != nil {
+		t.Fatalf("Failed to parse SRT: %s", err)
+	}
+	assertEquals(t, len(parsed), 2)
+	assertEquals(t, parsed[1].Text, "Two\nLines")
+
+	codec, _ := subtitleCodec("output.mp4", ".ass")
+	assertEquals(t, codec, "mov_text")
+	codec, _ = subtitleCodec("output.MKV", ".ass")
+	assertEquals(t, codec, "ass")
+	codec, _ = subtitleCodec("output.mkv", ".srt")
+	assertEquals(t, codec, "srt")
+	if _, err := subtitleCodec("output.avi", ".srt"); err == nil {
+		t.Errorf("Expected error for container without subtitle support")
+	}
+
+	assertEquals(t, escapeFilterValue(`C:\subs\it's [1].srt`), `C\\:\\\\subs\\\\it\\\'s \[1\].srt`)
+}
+
+func TestChapters(t *testing.T) {
+	chapter := parseChapter(parseCompact("chapter|id=0|time_base=1/1000|start=0|start_time=0.000000|end=90500|end_time=90.500000|tag:title=Intro"))
+	assertEquals(t, chapter, Chapter{Title: "Intro", Start: 0, End: 90.5})
+
+	chapters := []Chapter{chapter, {Title: "Q&A; a=b", Start: 90.5, End: 120}}
+	assertEquals(t, validateChapters(chapters), nil)
+	assertEquals(
+		t,
+		formatChapters(chapters),
+		";FFMETADATA1\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=0\nEND=90500\ntitle=Intro\n"+
+			"[CHAPTER]\nTIMEBASE=1/1000\nSTART=90500\nEND=120000\ntitle=Q&A\\; a\\=b\n",
+	)
+
+	if err := validateChapters([]Chapter{{Title: "Empty", Start: 10, End: 10}}); err == nil {
+		t.Errorf("Expected error for empty chapter")
+	}
+}
+
+func TestMetadataArgs(t *testing.T) {
+	options := &Options{}
+	options.SetTitle("Lecture 1")
+	options.SetComment("a=b")
+	options.SetCreationTime(time.Date(2024, 5, 1, 14, 30, 0, 0, time.FixedZone("CEST", 2*3600)))
+	options.SetLanguage("a:0", "eng")
+	options.SetRotation(-90)
+
+	assertEquals(
+		t,
+		strings.Join(metadataArgs(options.Metadata, options.StreamMetadata), " "),
+		"-metadata comment=a=b -metadata creation_time=2024-05-01T12:30:00.000000Z -metadata title=Lecture 1 "+
+			"-metadata:s:a:0 language=eng",
+	)
+	assertEquals(t, len(metadataArgs(nil, nil)), 0)
+
+	// 270 degrees clockwise is 90 degrees counter-clockwise.
+	assertEquals(t, options.Rotation, 270)
+	assertEquals(t, strings.Join(rotationArgs(options.Rotation), " "), "-display_rotation 90 -autorotate 0")
+	assertEquals(t, len(rotationArgs(0)), 0)
+}
+
+func TestFormatMetadataParsing(t *testing.T) {
+	metadata := parseFormatMetadata(parseCompact(
+		"format|filename=clip.mov|format_name=mov,mp4,m4a,3gp,3g2,mj2|tag:creation_time=2023-05-01T12:30:00.000000Z|" +
+			"tag:com.apple.quicktime.creationdate=2023-05-01T14:30:00+0200|tag:com.apple.quicktime.make=Apple|" +
+			"tag:com.apple.quicktime.model=iPhone 12|tag:com.apple.quicktime.software=16.4|" +
+			"tag:com.apple.quicktime.location.ISO6709=+37.3349-122.0090+030.500/|tag:encoder=Lavf60.3.100",
+	))
+
+	assertEquals(t, metadata.CreationTime.Equal(time.Date(2023, 5, 1, 12, 30, 0, 0, time.UTC)), true)
+	_, offset := metadata.CreationTime.Zone()
+	assertEquals(t, offset, 2*3600)
+	assertEquals(t, *metadata.Location, Location{Latitude: 37.3349, Longitude: -122.009, Altitude: 30.5})
+	assertEquals(t, metadata.Make, "Apple")
+	assertEquals(t, metadata.Model, "iPhone 12")
+	assertEquals(t, metadata.Software, "16.4")
+	assertEquals(t, metadata.Encoder, "Lavf60.3.100")
+	assertEquals(t, metadata.Tags["com.apple.quicktime.make"], "Apple")
+
+	// Degrees and minutes without altitude.
+	location := parseISO6709("+4030.5-07400.0/")
+	assertEquals(t, *location, Location{Latitude: 40.508333333333333, Longitude: -74})
+
+	empty := parseFormatMetadata(parseCompact("format|filename=clip.mp4"))
+	assertEquals(t, empty.CreationTime.IsZero(), true)
+	assertEquals(t, empty.Location == nil, true)
+	assertEquals(t, empty.Make, "")
+}
+
+func TestTimecode(t *testing.T) {
+	ntsc := 30000.0 / 1001.0
+
+	assertEquals(t, FrameTimecode(1799, ntsc, true).String(), "00:00:59;29")
+	assertEquals(t, FrameTimecode(1800, ntsc, true).String(), "00:01:00;02")
+	assertEquals(t, FrameTimecode(17982, ntsc, true).String(), "00:10:00;00")
+	assertEquals(t, FrameTimecode(3600, 60000.0/1001.0, true).String(), "00:01:00;04")
+	assertEquals(t, FrameTimecode(90000, 25, false).String(), "01:00:00:00")
+	// Drop-frame is ignored for frame rates without drop-frame counting.
+	assertEquals(t, FrameTimecode(90000, 25, true).String(), "01:00:00:00")
+
+	for frame := 0; frame < 200000; frame += 7 {
+		if tc := FrameTimecode(frame, ntsc, true); tc.Frame(ntsc) != frame {
+			t.Fatalf("Frame %d converted to %s and back to %d", frame, tc, tc.Frame(ntsc))
+		}
+	}
+
+	timecode, err := ParseTimecode("01:00:00;00")
+	if err != nil {
+		t.Fatalf("Failed to parse timecode: %s", err)
+	}
+	assertEquals(t, timecode, Timecode{Hours: 1, DropFrame: true})
+	assertEquals(t, timecode.Frame(ntsc), 107892)
+	assertEquals(t, timecode.Add(1800, ntsc).String(), "01:01:00;02")
+
+	timecode, _ = ParseTimecode("10:20:30:12")
+	assertEquals(t, timecode, Timecode{Hours: 10, Minutes: 20, Seconds: 30, Frames: 12})
+
+	for _, invalid := range []string{"", "01:00:00", "01:60:00:00", "aa:00:00:00", "00:01:00;00", "00:01:00;01"} {
+		if _, err := ParseTimecode(invalid); err == nil {
+			t.Errorf("Expected error for timecode %q", invalid)
+		}
+	}
+
+	// Frame numbers 00 and 01 exist every tenth minute.
+	timecode, _ = ParseTimecode("00:10:00;00")
+	assertEquals(t, timecode.Valid(ntsc), nil)
+
+	timecode, _ = ParseTimecode("00:00:10:25")
+	if timecode.Valid(25) == nil {
+		t.Errorf("Expected error for frame 25 at 25 fps")
+	}
+	assertEquals(t, timecode.Valid(30), nil)
+
+	// 59.94 fps skips four frame numbers.
+	timecode, _ = ParseTimecode("00:01:00;03")
+	if timecode.Valid(60000.0/1001.0) == nil {
+		t.Errorf("Expected error for dropped frame number at 59.94 fps")
+	}
+	assertEquals(t, timecode.Valid(ntsc), nil)
+
+	// Drop-frame counting does not exist at 25 fps.
+	if timecode.Valid(25) == nil {
+		t.Errorf("Expected error for drop-frame timecode at 25 fps")
+	}
+	if _, err := NewVideoWriter("out.mp4", 480, 270, &Options{FPS: 25, Timecode: "01:00:00;00"}); err == nil {
+		t.Errorf("Expected error for drop-frame timecode at 25 fps")
+	}
+}
+
+func TestEncoderArgs(t *testing.T) {
+	settings := encoderSettings{
+		preset:  "slow",
+		tune:    "film",
+		profile: "high",
+		level:   "4.1",
+		keyint:  48,
+		bframes: -1,
+		refs:    4,
+		params:  map[string]string{"aq-mode": "3", "no-fast-pskip": "1"},
+	}
+
+	args, err := encoderArgs("libx264", settings)
+	if err != nil {
+		t.Fatalf("Failed to build encoder arguments: %s", err)
+	}
+	assertEquals(
+		t,
+		strings.Join(args, " "),
+		"-preset slow -tune film -profile:v high -level:v 4.1 -g 48 -bf 0 -refs 4 -x264-params aq-mode=3:no-fast-pskip=1",
+	)
+
+	// HEVC settings without ffmpeg options are passed as x265 parameters.
+	args, _ = encoderArgs("hevc", settings)
+	assertEquals(
+		t,
+		strings.Join(args, " "),
+		"-preset slow -tune film -profile:v high -g 48 -x265-params level-idc=4.1:bframes=0:ref=4:aq-mode=3:no-fast-pskip=1",
+	)
+
+	args, _ = encoderArgs("libvpx-vp9", encoderSettings{preset: "good", keyint: 120, params: map[string]string{"row-mt": "1"}})
+	assertEquals(t, strings.Join(args, " "), "-deadline good -g 120 -row-mt 1")
+
+	if _, err := encoderArgs("libvpx-vp9", encoderSettings{bframes: 2}); err == nil {
+		t.Errorf("Expected error for unsupported B-frames setting")
+	}
+
+	args, _ = encoderArgs("libx264", encoderSettings{})
+	assertEquals(t, len(args), 0)
+}
+
+func TestGOPArgs(t *testing.T) {
+	settings := encoderSettings{keyint: 48, closed: true, fixed: true, forced: true}
+
+	args, err := encoderArgs("libx264", settings)
+	if err != nil {
+		t.Fatalf("Failed to build encoder arguments: %s", err)
+	}
+	assertEquals(t, strings.Join(args, " "), "-g 48 -keyint_min 48 -flags +cgop -sc_threshold 0 -forced-idr 1")
+
+	args, _ = encoderArgs("libx265", settings)
+	assertEquals(t, strings.Join(args, " "), "-g 48 -forced-idr 1 -x265-params min-keyint=48:open-gop=0:scenecut=0")
+
+	// VP9 GOPs are always closed and forced keyframes are always key frames.
+	args, _ = encoderArgs("libvpx-vp9", settings)
+	assertEquals(t, strings.Join(args, " "), "-g 48 -keyint_min 48")
+
+	args, _ = encoderArgs("libsvtav1", settings)
+	assertEquals(t, strings.Join(args, " "), "-g 48 -svtav1-params irefresh-type=2:scd=0")
+
+	if _, err := encoderArgs("libx264", encoderSettings{fixed: true}); err == nil {
+		t.Errorf("Expected error for fixed GOP without keyframe interval")
+	}
+
+	// Keyframes can only be written when the frames are buffered.
+	writer := &VideoWriter{}
+	if err := writer.WriteKeyframe(nil); err == nil {
+		t.Errorf("Expected error for WriteKeyframe without buffering")
+	}
+	assertEquals(t, writer.frames, 0)
+
+	assertEquals(t, keyframeExpression("", []int{0, 30, 95}), "expr:eq(n,0)+eq(n,30)+eq(n,95)")
+	assertEquals(t, keyframeExpression("expr:gte(t,n_forced*2)", []int{0}), "expr:gte(t,n_forced*2)")
+}
+
+func TestQualityArgs(t *testing.T) {
+	cases := []struct {
+		codec    string
+		quality  float64
+		expected string
+	}{
+		{"libx264", 0.5, "-crf 25"},
+		{"libx265", 0, "-crf 0"},
+		{"hevc", 1, "-crf 51"},
+		{"libvpx", 0, "-crf 4 -b:v 10M"},
+		{"vp8", 1, "-crf 63 -b:v 10M"},
+		{"libvpx-vp9", 0.5, "-crf 31 -b:v 0"},
+		{"libaom-av1", 1, "-crf 63 -b:v 0"},
+		{"libsvtav1", 0, "-crf 1"},
+		{"mpeg4", 0.5, "-qscale:v 16"},
+		{"mjpeg", 0, "-qscale:v 1"},
+		{"msmpeg4", 1, "-qscale:v 31"},
+	}
+
+	for _, c := range cases {
+		assertEquals(t, strings.Join(qualityArgs(c.codec, c.quality), " "), c.expected)
+	}
+}
+
+func TestRateArgs(t *testing.T) {
+	rate := func(codec string, bitrate, maxrate, bufsize int, cbr bool) string {
+		args, _ := rateArgs(codec, bitrate, maxrate, bufsize, cbr)
+		return strings.Join(args, " ")
+	}
+	assertEquals(t, rate("libvpx-vp9", 1000000, 0, 0, false), "")
+	assertEquals(t, rate("libvpx-vp9", 1000000, 2000000, 0, false), "-maxrate 2000000 -bufsize 4000000")
+	assertEquals(t, rate("libvpx-vp9", 1000000, 2000000, 1000000, false), "-maxrate 2000000 -bufsize 1000000")
+	assertEquals(t, rate("libvpx-vp9", 0, 0, 500000, false), "-bufsize 500000")
+	assertEquals(t, rate("libvpx-vp9", 1000000, 0, 0, true), "-minrate 1000000 -maxrate 1000000 -bufsize 1000000")
+	assertEquals(t, rate("libvpx-vp9", 1000000, 3000000, 2000000, true), "-minrate 1000000 -maxrate 1000000 -bufsize 2000000")
+
+	// libx264 and libx265 need their own options for constant bitrate.
+	assertEquals(t, rate("h264", 1000000, 0, 0, true), "-minrate 1000000 -nal-hrd cbr -maxrate 1000000 -bufsize 1000000")
+	assertEquals(t, rate("libx264", 1000000, 2000000, 0, false), "-maxrate 2000000 -bufsize 4000000")
+	rateargs, rateparams := rateArgs("hevc", 1000000, 0, 0, true)
+	assertEquals(t, strings.Join(rateargs, " "), "-minrate 1000000 -maxrate 1000000 -bufsize 1000000")
+	assertEquals(t, rateparams["strict-cbr"], "1")
+	rateargs, _ = encoderArgs("libx265", encoderSettings{params: rateparams})
+	assertEquals(t, strings.Join(rateargs, " "), "-x265-params strict-cbr=1")
+	_, rateparams = rateArgs("libx265", 1000000, 2000000, 0, false)
+	assertEquals(t, len(rateparams), 0)
+
+	args, params := passArgs("libx264", 1, "/tmp/log")
+	assertEquals(t, strings.Join(args, " "), "-pass 1 -passlogfile /tmp/log")
+	assertEquals(t, len(params), 0)
+
+	args, params = passArgs("hevc", 2, "/tmp/log")
+	assertEquals(t, len(args), 0)
+	assertEquals(t, params["pass"], "2")
+	assertEquals(t, params["stats"], "/tmp/log.log")
+
+	settings := map[string]string{"aq-mode": "3"}
+	merged := mergeParams(settings, params)
+	assertEquals(t, len(merged), 3)
+	assertEquals(t, len(settings), 1)
+
+	assertEquals(t, twoPassSupported("h264"), true)
+	assertEquals(t, twoPassSupported("libsvtav1"), false)
+	assertEquals(t, twoPassSupported("gif"), false)
+
+	// 10 MB in 60 seconds with 128 kbit/s audio.
+	assertEquals(t, BitrateForSize(10000000, 60, 128000), 1178666)
+	assertEquals(t, BitrateForSize(1000, 60, 128000), 0)
+	assertEquals(t, BitrateForSize(10000000, 0, 0), 0)
+}
+
 func TestImageRead(t *testing.T) {
 	w, h, img, err := Read("test/bananas.jpg")
 	if err != nil {